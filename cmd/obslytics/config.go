@@ -0,0 +1,335 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/thanos/pkg/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+const (
+	aggrCount = "count"
+	aggrSum   = "sum"
+	aggrMin   = "min"
+	aggrMax   = "max"
+)
+
+// defaultDelay is the default delay of incremental exports, same as of export --delay.
+const defaultDelay = 5 * time.Minute
+
+// aggregations lists all supported aggregations.
+var aggregations = []string{aggrCount, aggrSum, aggrMin, aggrMax}
+
+// emptyWindowsModes lists all supported modes of exporting windows without samples.
+var emptyWindowsModes = []string{string(dataframe.EmptyWindowsSkip), string(dataframe.EmptyWindowsNull), string(dataframe.EmptyWindowsFill)}
+
+// jobsConfig describes export jobs.
+type jobsConfig struct {
+	Jobs []jobConfig `yaml:"jobs"`
+}
+
+// jobConfig describes a single export job: what to read, from where, and where to export it to.
+type jobConfig struct {
+	Name string `yaml:"name"`
+
+	Match []string           `yaml:"match"`
+	Query string             `yaml:"query"`
+	Step  prommodel.Duration `yaml:"step"`
+
+	Input  series.Config   `yaml:"input"`
+	Output exporter.Config `yaml:"output"`
+
+	// Resolution is a duration (e.g. 5m) or calendar resolution (1d, 1w or 1mo) aligned in Timezone. Can be
+	// a comma separated list of resolutions, see export --resolution.
+	Resolution         string `yaml:"resolution"`
+	Timezone           string `yaml:"timezone"`
+	CombineResolutions bool   `yaml:"combine_resolutions"`
+	// ResolutionOffset shifts the window starts, e.g. 15m for 1h windows starting at :15.
+	ResolutionOffset prommodel.Duration `yaml:"resolution_offset"`
+	resolutions      []dataframe.Resolution
+	// Aggregations to export, all by default.
+	Aggregations   []string `yaml:"aggregations"`
+	QualityColumns bool     `yaml:"quality_columns"`
+	Combine        bool     `yaml:"combine"`
+	MetricColumn   string   `yaml:"metric_column"`
+	// JoinOn and JoinLabels join all match selectors into a single wide table, see export --join-on.
+	JoinOn     []string `yaml:"join_on"`
+	JoinLabels []string `yaml:"join_labels"`
+	// Pivot turns the values of the label into separate aggregation columns, see export --pivot.
+	Pivot            string `yaml:"pivot"`
+	PivotLabelPrefix bool   `yaml:"pivot_label_prefix"`
+	// EmptyWindows is one of skip (default), null or fill, see export --empty-windows.
+	EmptyWindows string             `yaml:"empty_windows"`
+	FillLookback prommodel.Duration `yaml:"fill_lookback"`
+
+	// MinTime and MaxTime is the time range to export, in RFC3339 or duration format. With incremental or scheduled
+	// exports, MinTime is the start of the first export and MaxTime is not allowed.
+	MinTime     timeOrDuration `yaml:"min_time"`
+	MaxTime     timeOrDuration `yaml:"max_time"`
+	Incremental bool           `yaml:"incremental"`
+	// Delay of the end of the incremental export behind the current time, 5m by default.
+	Delay prommodel.Duration `yaml:"delay"`
+
+	SplitInterval     prommodel.Duration `yaml:"split_interval"`
+	ReadConcurrency   int                `yaml:"read_concurrency"`
+	OutputConcurrency int                `yaml:"output_concurrency"`
+
+	// Interval between scheduled exports.
+	Interval prommodel.Duration `yaml:"interval"`
+	// Jitter is the maximum random delay before each scheduled export.
+	Jitter prommodel.Duration `yaml:"jitter"`
+}
+
+// UnmarshalYAML sets the defaults that can't be told apart from explicit zero values after parsing.
+func (j *jobConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*j = jobConfig{Delay: prommodel.Duration(defaultDelay)}
+	type plain jobConfig
+	return unmarshal((*plain)(j))
+}
+
+var envRefRe = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references with values of the environment variables and $$ with a single $. Other
+// uses of $, e.g. $1 in PromQL, are kept as they are.
+func expandEnv(s string) string {
+	return envRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// parseJobsConfig parses and validates the jobs configuration. Environment variables referenced as ${VAR} are
+// expanded before parsing, e.g. to not store secrets in the configuration. Use $$ for a literal $ before {.
+func parseJobsConfig(b []byte) (jobsConfig, error) {
+	cfg := jobsConfig{}
+	if err := yaml.UnmarshalStrict([]byte(expandEnv(string(b))), &cfg); err != nil {
+		return cfg, errors.Wrap(err, "parsing jobs configuration")
+	}
+	if len(cfg.Jobs) == 0 {
+		return cfg, errors.New("no jobs configured")
+	}
+
+	names := map[string]struct{}{}
+	for i := range cfg.Jobs {
+		j := &cfg.Jobs[i]
+		if j.Name == "" {
+			return cfg, errors.Newf("job %d: name is required", i)
+		}
+		if _, ok := names[j.Name]; ok {
+			return cfg, errors.Newf("job %s: duplicated name", j.Name)
+		}
+		names[j.Name] = struct{}{}
+
+		if j.Resolution == "" {
+			return cfg, errors.Newf("job %s: resolution is required", j.Name)
+		}
+		res, err := parseResolutions(j.Resolution, j.Timezone, time.Duration(j.ResolutionOffset))
+		if err != nil {
+			return cfg, errors.Wrapf(err, "job %s", j.Name)
+		}
+		j.resolutions = res
+		if err := validateAggregations(j.Aggregations); err != nil {
+			return cfg, errors.Wrapf(err, "job %s", j.Name)
+		}
+		switch j.EmptyWindows {
+		case "":
+			j.EmptyWindows = string(dataframe.EmptyWindowsSkip)
+		case string(dataframe.EmptyWindowsSkip), string(dataframe.EmptyWindowsNull), string(dataframe.EmptyWindowsFill):
+		default:
+			return cfg, errors.Newf("job %s: unsupported empty_windows %q, expected one of %v", j.Name, j.EmptyWindows, emptyWindowsModes)
+		}
+		if j.FillLookback <= 0 {
+			j.FillLookback = prommodel.Duration(5 * time.Minute)
+		}
+		if j.Step <= 0 {
+			j.Step = prommodel.Duration(30 * time.Second)
+		}
+		if j.Output.Path == "" {
+			return cfg, errors.Newf("job %s: output path is required", j.Name)
+		}
+		if j.Output.Storage.Type == "" {
+			// Default Storage Type is Filesystem.
+			j.Output.Storage.Type = client.FILESYSTEM
+		}
+		if j.ReadConcurrency <= 0 {
+			j.ReadConcurrency = 1
+		}
+		if j.OutputConcurrency <= 0 {
+			j.OutputConcurrency = 1
+		}
+	}
+	return cfg, nil
+}
+
+// validateExport checks the job can be exported once.
+func (j jobConfig) validateExport() error {
+	if j.Incremental {
+		if isTimeSet(j.MaxTime.TimeOrDurationValue) {
+			return errors.Newf("job %s: max_time can't be used with incremental export, use delay instead", j.Name)
+		}
+		return errors.Wrapf(validateNestedResolutions(j.resolutions), "job %s", j.Name)
+	}
+	if !isTimeSet(j.MinTime.TimeOrDurationValue) || !isTimeSet(j.MaxTime.TimeOrDurationValue) {
+		return errors.Newf("job %s: min_time and max_time are required, unless incremental is used", j.Name)
+	}
+	return nil
+}
+
+// validateSchedule checks the job can be exported periodically.
+func (j jobConfig) validateSchedule() error {
+	if j.Interval <= 0 {
+		return errors.Newf("job %s: interval is required", j.Name)
+	}
+	if j.Jitter >= j.Interval {
+		return errors.Newf("job %s: jitter has to be lower than interval", j.Name)
+	}
+	if isTimeSet(j.MaxTime.TimeOrDurationValue) {
+		return errors.Newf("job %s: max_time can't be used with scheduled export, use delay instead", j.Name)
+	}
+	return errors.Wrapf(validateNestedResolutions(j.resolutions), "job %s", j.Name)
+}
+
+// exportParams returns parameters of the export of the job.
+func (j jobConfig) exportParams() exportParams {
+	return exportParams{
+		matchers:           j.Match,
+		query:              j.Query,
+		step:               time.Duration(j.Step),
+		mint:               j.MinTime.TimeOrDurationValue,
+		maxt:               j.MaxTime.TimeOrDurationValue,
+		resolutions:        j.resolutions,
+		combineResolutions: j.CombineResolutions,
+		aggregations:       j.Aggregations,
+		qualityColumns:     j.QualityColumns,
+		emptyWindows:       dataframe.EmptyWindowsMode(j.EmptyWindows),
+		fillLookback:       time.Duration(j.FillLookback),
+		combine:            j.Combine,
+		metricColumn:       j.MetricColumn,
+		joinOn:             j.JoinOn,
+		joinLabels:         j.JoinLabels,
+		pivot:              j.Pivot,
+		pivotLabelPrefix:   j.PivotLabelPrefix,
+		splitInterval:      time.Duration(j.SplitInterval),
+		readConcurrency:    j.ReadConcurrency,
+		outputConcurrency:  j.OutputConcurrency,
+		incremental:        j.Incremental,
+		job:                j.Name,
+		delay:              time.Duration(j.Delay),
+	}
+}
+
+// parseResolutions parses the comma separated list of resolutions, with calendar resolutions aligned in the given
+// timezone, UTC if empty, and windows of all of them shifted by the offset.
+func parseResolutions(resolutions, timezone string, offset time.Duration) ([]dataframe.Resolution, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "timezone %q", timezone)
+	}
+	if offset < 0 {
+		return nil, errors.Newf("resolution offset %s can't be negative", offset)
+	}
+
+	var (
+		ret  []dataframe.Resolution
+		seen = map[string]struct{}{}
+	)
+	for _, s := range strings.Split(resolutions, ",") {
+		res, err := dataframe.ParseResolution(strings.TrimSpace(s), loc)
+		if err != nil {
+			return nil, err
+		}
+		res = dataframe.WithOffset(res, offset)
+		// Resolutions name their output objects, so they have to be distinct.
+		if _, ok := seen[res.String()]; ok {
+			return nil, errors.Newf("duplicated resolution %q", s)
+		}
+		seen[res.String()] = struct{}{}
+		ret = append(ret, res)
+	}
+	return ret, nil
+}
+
+// nestedResolutionsHorizon is the number of windows of the coarser resolution checked to start windows of the finer
+// ones, e.g. years of days to cover the daylight saving time changes.
+const nestedResolutionsHorizon = 1000
+
+// validateNestedResolutions checks every window of a coarser resolution starts a window of all finer ones, so the
+// windows of all resolutions are complete at the end of an incremental export. E.g. 5m,1h,1d are nested, but 1w,1mo
+// are not, as months do not start on Monday.
+func validateNestedResolutions(resolutions []dataframe.Resolution) error {
+	ref := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	length := func(r dataframe.Resolution) time.Duration {
+		start := r.WindowStart(ref)
+		return r.WindowEnd(start).Sub(start)
+	}
+	for _, coarse := range resolutions {
+		for _, fine := range resolutions {
+			if length(fine) >= length(coarse) {
+				continue
+			}
+			start := coarse.WindowStart(ref)
+			for i := 0; i < nestedResolutionsHorizon; i++ {
+				if !fine.WindowStart(start).Equal(start) {
+					return errors.Newf("resolution %s is not nested in %s, %s window starting at %v is not aligned with %s windows",
+						fine, coarse, coarse, start, fine)
+				}
+				start = coarse.WindowEnd(start)
+			}
+		}
+	}
+	return nil
+}
+
+// resolutionTimezone returns the timezone of the calendar resolutions, if any.
+func resolutionTimezone(resolutions ...dataframe.Resolution) string {
+	for _, res := range resolutions {
+		if r, ok := res.(dataframe.CalendarResolution); ok && r.Location != nil {
+			return r.Location.String()
+		}
+	}
+	return ""
+}
+
+func validateAggregations(aggrs []string) error {
+	for _, a := range aggrs {
+		found := false
+		for _, s := range aggregations {
+			found = found || a == s
+		}
+		if !found {
+			return errors.Newf("unsupported aggregation %q, expected one of %v", a, aggregations)
+		}
+	}
+	return nil
+}
+
+// timeOrDuration allows to specify model.TimeOrDurationValue in YAML.
+type timeOrDuration struct {
+	model.TimeOrDurationValue
+}
+
+func (t *timeOrDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+	return t.Set(s)
+}
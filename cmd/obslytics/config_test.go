@@ -0,0 +1,157 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/objstore/client"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+)
+
+func TestParseJobsConfig(t *testing.T) {
+	testutil.Ok(t, os.Setenv("OBSLYTICS_TEST_TOKEN", "secret"))
+	t.Cleanup(func() { _ = os.Unsetenv("OBSLYTICS_TEST_TOKEN") })
+
+	cfg, err := parseJobsConfig([]byte(`
+jobs:
+- name: up
+  match: ['up{job="prometheus"}']
+  join_on: [namespace, pod]
+  join_labels: [label_team]
+  input:
+    endpoint: localhost:10901
+    type: STOREAPI
+  output:
+    type: PARQUET
+    storage:
+      type: FILESYSTEM
+      config:
+        directory: /tmp/obslytics
+    path: up.parquet
+  resolution: 5m
+  min_time: 2020-01-01T00:00:00Z
+  incremental: true
+  interval: 1h
+  jitter: 1m
+- name: query
+  query: sum by (host) (label_replace(up, "host", "$1", "instance", "^(.*):[0-9]+$"))
+  input:
+    endpoint: http://localhost:9090
+    type: PROMQL
+    bearer_token: ${OBSLYTICS_TEST_TOKEN}
+    headers:
+      X-Scope: $${literal}
+  output:
+    type: PARQUET
+    path: sum.parquet
+  resolution: 1d, 1mo
+  timezone: Europe/Prague
+  resolution_offset: 6h
+  combine_resolutions: true
+  aggregations: [sum, max]
+  quality_columns: true
+  pivot: job
+  pivot_label_prefix: true
+  read_concurrency: 4
+  empty_windows: fill
+  min_time: -1d
+  max_time: 0s
+  delay: 0s
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(cfg.Jobs))
+
+	p := cfg.Jobs[0].exportParams()
+	testutil.Equals(t, []string{`up{job="prometheus"}`}, p.matchers)
+	testutil.Equals(t, []dataframe.Resolution{dataframe.FixedResolution(5 * time.Minute)}, p.resolutions)
+	testutil.Equals(t, 30*time.Second, p.step)
+	testutil.Equals(t, []string{"namespace", "pod"}, p.joinOn)
+	testutil.Equals(t, []string{"label_team"}, p.joinLabels)
+	testutil.Equals(t, "up", p.job)
+	testutil.Assert(t, p.incremental)
+	testutil.Equals(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), p.mint.PrometheusTimestamp())
+	testutil.Equals(t, 5*time.Minute, p.delay)
+	testutil.Equals(t, dataframe.EmptyWindowsSkip, p.emptyWindows)
+	testutil.Ok(t, cfg.Jobs[0].validateExport())
+	testutil.Ok(t, cfg.Jobs[0].validateSchedule())
+
+	// Only ${VAR} references are expanded.
+	testutil.Equals(t, `sum by (host) (label_replace(up, "host", "$1", "instance", "^(.*):[0-9]+$"))`, cfg.Jobs[1].Query)
+	testutil.Equals(t, "${literal}", cfg.Jobs[1].Input.Headers["X-Scope"])
+	testutil.Equals(t, client.FILESYSTEM, cfg.Jobs[1].Output.Storage.Type)
+	testutil.Equals(t, "secret", string(cfg.Jobs[1].Input.BearerToken))
+
+	p = cfg.Jobs[1].exportParams()
+	testutil.Equals(t, "1d+6h,1mo+6h", p.resolutionsString())
+	testutil.Equals(t, "Europe/Prague", resolutionTimezone(p.resolutions...))
+	testutil.Assert(t, p.combineResolutions)
+	testutil.Equals(t, []string{"sum", "max"}, p.aggregations)
+	testutil.Assert(t, p.qualityColumns)
+	testutil.Equals(t, "job", p.pivot)
+	testutil.Assert(t, p.pivotLabelPrefix)
+	testutil.Equals(t, 4, p.readConcurrency)
+	testutil.Equals(t, 1, p.outputConcurrency)
+	testutil.Equals(t, dataframe.EmptyWindowsFill, p.emptyWindows)
+	testutil.Equals(t, 5*time.Minute, p.fillLookback)
+	testutil.Assert(t, !p.incremental)
+	testutil.Equals(t, time.Duration(0), p.delay)
+	testutil.Assert(t, isTimeSet(p.mint) && isTimeSet(p.maxt))
+	testutil.Ok(t, cfg.Jobs[1].validateExport())
+	// Scheduled jobs are incremental, and run every interval.
+	testutil.NotOk(t, cfg.Jobs[1].validateSchedule())
+
+	for _, tcase := range []struct {
+		name, config string
+	}{
+		{name: "no jobs", config: `jobs: []`},
+		{name: "no output path", config: "jobs:\n- name: a\n  resolution: 5m"},
+		{name: "unknown field", config: "jobs:\n- name: a\n  resolution: 5m\n  unknown: 1"},
+		{name: "no name", config: "jobs:\n- resolution: 5m"},
+		{name: "duplicated name", config: "jobs:\n- name: a\n  resolution: 5m\n- name: a\n  resolution: 5m"},
+		{name: "no resolution", config: "jobs:\n- name: a"},
+		{name: "wrong resolution", config: "jobs:\n- name: a\n  resolution: 1 month"},
+		{name: "duplicated resolution", config: "jobs:\n- name: a\n  resolution: 60m,1h"},
+		{name: "negative resolution offset", config: "jobs:\n- name: a\n  resolution: 1h\n  resolution_offset: -15m"},
+		{name: "unknown timezone", config: "jobs:\n- name: a\n  resolution: 1d\n  timezone: Mars/Olympus"},
+		{name: "wrong min time", config: "jobs:\n- name: a\n  resolution: 5m\n  min_time: yesterday"},
+		{name: "unknown empty windows mode", config: "jobs:\n- name: a\n  resolution: 5m\n  empty_windows: zero"},
+		{name: "unknown aggregation", config: "jobs:\n- name: a\n  resolution: 5m\n  aggregations: [avg]"},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			_, err := parseJobsConfig([]byte(tcase.config))
+			testutil.NotOk(t, err)
+		})
+	}
+}
+
+func TestValidateNestedResolutions(t *testing.T) {
+	for _, tcase := range []struct {
+		resolutions, timezone string
+		offset                time.Duration
+		nested                bool
+	}{
+		{resolutions: "5m,1h,1d", nested: true},
+		{resolutions: "1d,1mo", timezone: "Europe/Prague", offset: 6 * time.Hour, nested: true},
+		{resolutions: "30m,1d", timezone: "Asia/Kolkata", nested: true},
+		{resolutions: "1h,1d", timezone: "Asia/Kolkata"},
+		{resolutions: "1w,1mo"},
+		{resolutions: "7m,1h"},
+	} {
+		t.Run(tcase.resolutions, func(t *testing.T) {
+			res, err := parseResolutions(tcase.resolutions, tcase.timezone, tcase.offset)
+			testutil.Ok(t, err)
+			err = validateNestedResolutions(res)
+			if tcase.nested {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotOk(t, err)
+		})
+	}
+}
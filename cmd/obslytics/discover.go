@@ -0,0 +1,287 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/efficientgo/core/logerrcapture"
+	"github.com/go-kit/log"
+	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-io/thanos/pkg/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	infactory "github.com/thanos-community/obslytics/pkg/series/factory"
+)
+
+// discoveryFlags are flags shared by the commands discovering series of the input.
+type discoveryFlags struct {
+	input      *extflag.PathOrContent
+	match      *string
+	mint, maxt *model.TimeOrDurationValue
+	limit      *int
+}
+
+func registerDiscoveryFlags(cmd *kingpin.CmdClause, matchRequired bool) discoveryFlags {
+	f := discoveryFlags{
+		input: extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration.", extflag.WithRequired()),
+		mint: model.TimeOrDuration(cmd.Flag("min-time", fmt.Sprintf("The lower boundary of the time series in %s or duration format.", time.RFC3339)).
+			Default("-1h")),
+		maxt: model.TimeOrDuration(cmd.Flag("max-time", fmt.Sprintf("The upper boundary of the time series in %s or duration format.", time.RFC3339)).
+			Default("0s")),
+		limit: cmd.Flag("limit", "Maximum number of series or label values to print. Cardinality is always computed from all of them.").Default("100").Int(),
+	}
+	matchFlag := cmd.Flag("match", "Series selector (e.g up{a=\"1\"}).")
+	if matchRequired {
+		matchFlag = matchFlag.Required()
+	}
+	f.match = matchFlag.String()
+	return f
+}
+
+// reader returns the input reader and the params to read based on the flags.
+func (f discoveryFlags) reader(logger log.Logger) (series.Reader, series.Params, error) {
+	params := series.Params{
+		MinTime: timestamp.Time(f.mint.PrometheusTimestamp()),
+		MaxTime: timestamp.Time(f.maxt.PrometheusTimestamp()),
+	}
+	if *f.match != "" {
+		matchers, err := parser.ParseMetricSelector(*f.match)
+		if err != nil {
+			return nil, params, errors.Wrapf(err, "parsing provided matchers %q", *f.match)
+		}
+		params.Matchers = matchers
+	}
+
+	inputCfg, err := f.input.Content()
+	if err != nil {
+		return nil, params, err
+	}
+	inputConfig := series.Config{}
+	if err := yaml.UnmarshalStrict(inputCfg, &inputConfig); err != nil {
+		return nil, params, err
+	}
+	in, err := infactory.NewSeriesReader(logger, inputConfig)
+	return in, params, err
+}
+
+func registerSeries(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("series", "List series matching the selector, without reading their samples, together with cardinality of their labels.")
+	f := registerDiscoveryFlags(cmd, true)
+
+	m["series"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			in, params, err := f.reader(logger)
+			if err != nil {
+				return err
+			}
+			defer logerrcapture.Do(logger, in.Close, "close series reader")
+
+			return listSeries(ctx, os.Stdout, in, params, *f.limit)
+		}, func(error) { cancel() })
+		return nil
+	}
+}
+
+func registerLabels(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("labels", "List label names of the series matching the selector together with the number of their values, "+
+		"or values of the given --label.")
+	f := registerDiscoveryFlags(cmd, false)
+	label := cmd.Flag("label", "Label name to list the values of.").String()
+
+	m["labels"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			in, params, err := f.reader(logger)
+			if err != nil {
+				return err
+			}
+			defer logerrcapture.Do(logger, in.Close, "close series reader")
+
+			if *label != "" {
+				return listLabelValues(ctx, os.Stdout, in, params, *label, *f.limit)
+			}
+			return listLabels(ctx, os.Stdout, in, params)
+		}, func(error) { cancel() })
+		return nil
+	}
+}
+
+// listSeries prints up to limit series matching the params and the number of values of every label of all of them.
+func listSeries(ctx context.Context, w io.Writer, in series.Reader, params series.Params, limit int) error {
+	set, err := series.ListSeries(ctx, in, params)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = set.Close() }()
+
+	var (
+		numSeries int
+		values    = map[string]map[string]struct{}{}
+	)
+	for set.Next() {
+		ls := set.At().Labels()
+		if numSeries < limit {
+			fmt.Fprintln(w, ls.String())
+		}
+		numSeries++
+		addLabelValues(values, ls)
+	}
+	if err := set.Err(); err != nil {
+		return err
+	}
+	if numSeries > limit {
+		fmt.Fprintf(w, "... %d more series\n", numSeries-limit)
+	}
+
+	fmt.Fprintf(w, "\nseries: %d\n\n", numSeries)
+	return printCardinality(w, cardinality(values))
+}
+
+// listLabels prints label names of the series matching the params together with the number of their values.
+func listLabels(ctx context.Context, w io.Writer, in series.Reader, params series.Params) error {
+	card, err := labelCardinality(ctx, in, params)
+	if err != nil {
+		return err
+	}
+	return printCardinality(w, card)
+}
+
+// listLabelValues prints up to limit values of the label of the series matching the params.
+func listLabelValues(ctx context.Context, w io.Writer, in series.Reader, params series.Params, name string, limit int) error {
+	vals, err := labelValues(ctx, in, params, name)
+	if err != nil {
+		return err
+	}
+	sort.Strings(vals)
+
+	for i, v := range vals {
+		if i == limit {
+			fmt.Fprintf(w, "... %d more values\n", len(vals)-limit)
+			break
+		}
+		fmt.Fprintln(w, v)
+	}
+	fmt.Fprintf(w, "\nvalues: %d\n", len(vals))
+	return nil
+}
+
+// labelCardinality returns the number of values of every label of the series matching the params.
+// Labels are listed from the series if the input does not support querying them directly.
+func labelCardinality(ctx context.Context, in series.Reader, params series.Params) (map[string]int, error) {
+	if lr, ok := in.(series.LabelsReader); ok {
+		names, err := lr.LabelNames(ctx, params)
+		if !errors.Is(err, series.ErrLabelsNotSupported) {
+			if err != nil {
+				return nil, err
+			}
+			card := make(map[string]int, len(names))
+			for _, n := range names {
+				vals, err := lr.LabelValues(ctx, n, params)
+				if err != nil {
+					return nil, err
+				}
+				card[n] = len(vals)
+			}
+			return card, nil
+		}
+	}
+
+	values, err := seriesLabelValues(ctx, in, params)
+	if err != nil {
+		return nil, err
+	}
+	return cardinality(values), nil
+}
+
+// labelValues returns values of the label of the series matching the params.
+// Values are listed from the series if the input does not support querying them directly.
+func labelValues(ctx context.Context, in series.Reader, params series.Params, name string) ([]string, error) {
+	if lr, ok := in.(series.LabelsReader); ok {
+		vals, err := lr.LabelValues(ctx, name, params)
+		if !errors.Is(err, series.ErrLabelsNotSupported) {
+			return vals, err
+		}
+	}
+
+	values, err := seriesLabelValues(ctx, in, params)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]string, 0, len(values[name]))
+	for v := range values[name] {
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// seriesLabelValues returns values of every label of the series matching the params.
+func seriesLabelValues(ctx context.Context, in series.Reader, params series.Params) (map[string]map[string]struct{}, error) {
+	set, err := series.ListSeries(ctx, in, params)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = set.Close() }()
+
+	values := map[string]map[string]struct{}{}
+	for set.Next() {
+		addLabelValues(values, set.At().Labels())
+	}
+	return values, set.Err()
+}
+
+func addLabelValues(values map[string]map[string]struct{}, ls labels.Labels) {
+	for _, l := range ls {
+		if _, ok := values[l.Name]; !ok {
+			values[l.Name] = map[string]struct{}{}
+		}
+		values[l.Name][l.Value] = struct{}{}
+	}
+}
+
+func cardinality(values map[string]map[string]struct{}) map[string]int {
+	card := make(map[string]int, len(values))
+	for n, vals := range values {
+		card[n] = len(vals)
+	}
+	return card
+}
+
+// printCardinality prints label names with the number of their values, the highest first.
+func printCardinality(w io.Writer, card map[string]int) error {
+	names := make([]string, 0, len(card))
+	for n := range card {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if card[names[i]] != card[names[j]] {
+			return card[names[i]] > card[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tVALUES")
+	for _, n := range names {
+		fmt.Fprintf(tw, "%s\t%d\n", n, card[n])
+	}
+	return tw.Flush()
+}
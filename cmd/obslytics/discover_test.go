@@ -0,0 +1,84 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// labelsReader answers label queries instead of listing series.
+type labelsReader struct {
+	listingReader
+	values map[string][]string
+}
+
+func (r labelsReader) LabelNames(context.Context, series.Params) ([]string, error) {
+	var names []string
+	for n := range r.values {
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+func (r labelsReader) LabelValues(_ context.Context, name string, _ series.Params) ([]string, error) {
+	return r.values[name], nil
+}
+
+func TestDiscovery(t *testing.T) {
+	in := listingReader{series: []labels.Labels{
+		labels.FromStrings(labels.MetricName, "up", "job", "a", "instance", "1"),
+		labels.FromStrings(labels.MetricName, "up", "job", "a", "instance", "2"),
+		labels.FromStrings(labels.MetricName, "up", "job", "b", "instance", "3"),
+	}}
+	ctx := context.Background()
+
+	t.Run("series", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		testutil.Ok(t, listSeries(ctx, b, in, series.Params{}, 2))
+		testutil.Equals(t, `{__name__="up", instance="1", job="a"}
+{__name__="up", instance="2", job="a"}
+... 1 more series
+
+series: 3
+
+LABEL     VALUES
+instance  3
+job       2
+__name__  1
+`, b.String())
+	})
+
+	t.Run("labels listed from series", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		testutil.Ok(t, listLabels(ctx, b, in, series.Params{}))
+		testutil.Equals(t, `LABEL     VALUES
+instance  3
+job       2
+__name__  1
+`, b.String())
+
+		b.Reset()
+		testutil.Ok(t, listLabelValues(ctx, b, in, series.Params{}, "job", 10))
+		testutil.Equals(t, "a\nb\n\nvalues: 2\n", b.String())
+	})
+
+	t.Run("labels queried", func(t *testing.T) {
+		lr := labelsReader{listingReader: in, values: map[string][]string{"job": {"x", "y", "z"}}}
+
+		b := &bytes.Buffer{}
+		testutil.Ok(t, listLabels(ctx, b, lr, series.Params{}))
+		testutil.Equals(t, "LABEL  VALUES\njob    3\n", b.String())
+
+		b.Reset()
+		testutil.Ok(t, listLabelValues(ctx, b, lr, series.Params{}, "job", 1))
+		testutil.Equals(t, "x\n... 2 more values\n\nvalues: 3\n", b.String())
+	})
+}
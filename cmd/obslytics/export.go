@@ -6,18 +6,31 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/efficientgo/core/errors"
+	"github.com/efficientgo/core/logerrcapture"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/model"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v2"
 
+	"github.com/thanos-community/obslytics/pkg/checkpoint"
 	"github.com/thanos-community/obslytics/pkg/dataframe"
 	"github.com/thanos-community/obslytics/pkg/exporter"
 	"github.com/thanos-community/obslytics/pkg/series"
@@ -31,30 +44,102 @@ import (
 
 func registerExport(m map[string]setupFunc, app *kingpin.Application) {
 	cmd := app.Command("export", "Export observability series data into popular analytics formats.")
-	inputFlag := extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration.", extflag.WithRequired())
+	configFlag := extflag.RegisterPathOrContent(cmd, "config", "YAML with the list of export jobs, each with its match or query, input, output, resolution, "+
+		"aggregations and time range. Environment variables referenced as ${VAR} are expanded, e.g. for secrets, $$ escapes a literal $. Replaces the flags describing the export when set.")
+	inputFlag := extflag.RegisterPathOrContent(cmd, "input-config", "YAML for input, series configuration. Required unless --config is used.")
 	outputFlag := extflag.RegisterPathOrContent(cmd, "output-config", "YAML for dataframe export configuration.")
 
 	// TODO(bwplotka): Describe more how the format looks like.
-	matchersStr := cmd.Flag("match", "Metric matcher for metrics to export (e.g up{a=\"1\"}").Required().String()
+	matchers := cmd.Flag("match", "Metric matcher for metrics to export (e.g up{a=\"1\"}). Can be repeated to export multiple metrics in a single run, "+
+		"each into its own output object (see --combine). Required unless input type is PROMQL.").Strings()
+	combine := cmd.Flag("combine", "Export series of all --match selectors into a single output object. The metric name is kept in the --metric-column column.").Bool()
+	metricColumn := cmd.Flag("metric-column", "Name of the column to export the metric name to, switching the table to a long format where several metrics share one table "+
+		"(e.g. when using {__name__=~\"node_.*\"} matcher). By default the metric name is not exported, unless --combine is used, in which case __name__ column is used.").String()
+	joinOn := cmd.Flag("join-on", "Label to join series of all --match selectors on, into a single wide output object, e.g. namespace and pod to enrich "+
+		"container_cpu_usage_seconds_total with the ownership labels of kube_pod_labels. Can be repeated. Series of every selector are aggregated by these labels "+
+		"(and --join-label) in the same windows, and joined as with SQL full outer join. Aggregations are exported in columns prefixed with the metric name "+
+		"(or selector position), e.g. container_cpu_usage_seconds_total_sum.").Strings()
+	joinLabels := cmd.Flag("join-label", "Label to keep when joining with --join-on, without joining on it, e.g. label_team of kube_pod_labels. Can be repeated.").Strings()
+	pivot := cmd.Flag("pivot", "Label whose values are turned into separate aggregation columns, switching the table to a wide format with a row per window "+
+		"and values of the other labels, e.g. mode of node_cpu_seconds_total exported into idle_sum, user_sum and so on. Characters "+
+		"not valid in SQL identifiers are replaced by _, e.g. 0_99_sum, values pivoted into the same columns are rejected. Use __name__ to pivot metrics, "+
+		"e.g. into node_load1_sum. The columns depend on the label values present in every exported object.").String()
+	pivotLabelPrefix := cmd.Flag("pivot-label-prefix", "Prefix the --pivot columns with the label too, e.g. mode_idle_sum or quantile_0_99_sum.").Bool()
+	query := cmd.Flag("query", "PromQL expression to export (e.g sum by (team) (rate(http_requests_total[5m]))). Only used with PROMQL input type.").String()
+	step := cmd.Flag("step", "Query resolution step of the PromQL evaluation. Only used with PROMQL input type.").Default("30s").Duration()
 	timeFmt := time.RFC3339
 
 	var mint, maxt model.TimeOrDurationValue
-	cmd.Flag("min-time", fmt.Sprintf("The lower boundary of the time series in %s or duration format", timeFmt)).
-		Required().SetValue(&mint)
+	cmd.Flag("min-time", fmt.Sprintf("The lower boundary of the time series in %s or duration format. "+
+		"Required, unless --incremental is used and the job checkpoint exists.", timeFmt)).SetValue(&mint)
 
-	cmd.Flag("max-time", fmt.Sprintf("The upper boundary of the time series in %s or duration format", timeFmt)).
-		Required().SetValue(&maxt)
+	cmd.Flag("max-time", fmt.Sprintf("The upper boundary of the time series in %s or duration format. Required, unless --incremental is used.", timeFmt)).
+		SetValue(&maxt)
 
-	resolution := cmd.Flag("resolution", "Sample resolution (e.g. 30m)").Required().Duration()
+	incremental := cmd.Flag("incremental", "Continue from the end of the last export of the job, recorded in the checkpoint object stored next to the output, "+
+		"up to now - --delay. The output object is suffixed with the exported time range. Overlapping runs of the same job are detected "+
+		"on a best-effort basis only, so don't run them concurrently (see the schedule command).").Bool()
+	job := cmd.Flag("job", "Name of the incremental export job, used for the checkpoint object name. Defaults to the output object name.").String()
+	delay := cmd.Flag("delay", "Delay of the end of incremental export behind the current time, to let the data arrive to the input.").Default("5m").Duration()
+
+	resolution := cmd.Flag("resolution", "Sample resolution (e.g. 30m, 1d, 1w or 1mo). Days, weeks and months are calendar resolutions aligned "+
+		"to midnight, Monday and the first day of the month in --timezone. Can be a comma separated list (e.g. 5m,1h,1d) to aggregate the series read once "+
+		"in every resolution, each exported into its own output object suffixed with the resolution (see --combine-resolutions). "+
+		"Required unless --config is used.").String()
+	combineResolutions := cmd.Flag("combine-resolutions", "Export all --resolution resolutions into a single output object, "+
+		"with the resolution of every row in the _resolution column.").Bool()
+	resolutionOffset := cmd.Flag("resolution-offset", "Offset of the window starts, e.g. 15m for 1h windows starting at :15, or 6h for days starting at 06:00 "+
+		"of --timezone. Windows are half-open, samples at the window end belong to the next window.").Default("0s").Duration()
+	timezone := cmd.Flag("timezone", "Timezone of the calendar resolutions (e.g. Europe/Prague), following its daylight saving time.").Default("UTC").String()
+	emptyWindows := cmd.Flag("empty-windows", "How to export windows without samples of the series within the time range: skip them, "+
+		"export them with zero count and null other aggregations (null), or the same but with min and max forward-filled from the last sample "+
+		"within --fill-lookback, e.g. for gauges (fill). Stale markers, e.g. of targets being down, are never aggregated and stop the forward-fill.").
+		Default(string(dataframe.EmptyWindowsSkip)).Enum(emptyWindowsModes...)
+	fillLookback := cmd.Flag("fill-lookback", "Maximum time between the last sample and the start of the window forward-filled with --empty-windows=fill.").
+		Default("5m").Duration()
+	aggrs := cmd.Flag("aggregation", fmt.Sprintf("Aggregation to export for every window, one of %v. Can be repeated. All are exported by default.", aggregations)).
+		Enums(aggregations...)
+	qualityColumns := cmd.Flag("quality-columns", "Export the quality of the samples of every window: the expected number of samples at the scrape interval "+
+		"inferred from the consecutive samples of the series (_expected_count), the maximum gap between samples in seconds (_max_gap_seconds) "+
+		"and the ratio of the count to the expected count (_completeness), e.g. to tell missing scrapes from low values.").Bool()
+	splitInterval := cmd.Flag("split-interval", "Split the time range into sub-ranges of the given interval (e.g. 1d) read one after another, "+
+		"so a single request does not cover the whole time range. Rounded up to a multiple of --resolution. 0 disables splitting.").Default("0s").Duration()
+	readConcurrency := cmd.Flag("read-concurrency", "Maximum number of concurrent sub-range reads (see --split-interval), shared by all outputs. "+
+		"When greater than 1, reads are issued ahead of the aggregation, which consumes them in order, and buffered in memory.").Default("1").Int()
+	outputConcurrency := cmd.Flag("output-concurrency", "Maximum number of outputs (see --match) aggregated and encoded concurrently. "+
+		"Series of a single output are aggregated and encoded sequentially.").Default("1").Int()
+	shardCount := cmd.Flag("shard-count", "Total number of shards to split the series into by the hash of their labels, "+
+		"e.g. to run multiple obslytics instances each exporting its own part. 0 or 1 disables sharding.").Default("0").Int()
+	shardIndex := cmd.Flag("shard-index", "Index of the shard to export, from 0 to --shard-count - 1. The output object is suffixed with the shard.").Default("0").Int()
 	dbgOut := cmd.Flag("debug", "Show additional debug info (such as produced table)").Bool()
+	dryRun := cmd.Flag("dry-run", "Only list the series to export, without reading their samples, and print the number of series, "+
+		"the columns, the estimated number of rows and the object path of every output. Nothing is written.").Bool()
 
-	m["export"] = func(g *run.Group, logger log.Logger) error {
+	m["export"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry) error {
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
+			cfg, err := configFlag.Content()
+			if err != nil {
+				return err
+			}
+			if len(cfg) > 0 {
+				return exportJobs(ctx, logger, reg, cfg, *dbgOut, *dryRun)
+			}
+
 			inputCfg, err := inputFlag.Content()
 			if err != nil {
 				return err
 			}
+			if len(inputCfg) == 0 {
+				return errors.New("--input-config is required, unless --config is used")
+			}
+			if *resolution == "" {
+				return errors.New("--resolution is required, unless --config is used")
+			}
+			res, err := parseResolutions(*resolution, *timezone, *resolutionOffset)
+			if err != nil {
+				return err
+			}
 
 			inputConfig := series.Config{}
 			if err := yaml.UnmarshalStrict(inputCfg, &inputConfig); err != nil {
@@ -74,59 +159,575 @@ func registerExport(m map[string]setupFunc, app *kingpin.Application) {
 				return err
 			}
 
-			return export(ctx, logger, *matchersStr, inputConfig, outputConfig, mint, maxt, *resolution, *dbgOut)
+			if *incremental && isTimeSet(maxt) {
+				return errors.New("--max-time can't be used with --incremental, use --delay instead")
+			}
+			if *incremental {
+				if err := validateNestedResolutions(res); err != nil {
+					return errors.Wrap(err, "--incremental")
+				}
+			}
+			if !*incremental && (!isTimeSet(mint) || !isTimeSet(maxt)) {
+				return errors.New("--min-time and --max-time are required, unless --incremental is used")
+			}
+
+			jobName := *job
+			if jobName == "" {
+				jobName = defaultJob(outputConfig.Path)
+			}
+			return export(ctx, logger, inputConfig, outputConfig, exportParams{
+				matchers:           *matchers,
+				query:              *query,
+				step:               *step,
+				mint:               mint,
+				maxt:               maxt,
+				resolutions:        res,
+				combineResolutions: *combineResolutions,
+				aggregations:       *aggrs,
+				qualityColumns:     *qualityColumns,
+				emptyWindows:       dataframe.EmptyWindowsMode(*emptyWindows),
+				fillLookback:       *fillLookback,
+				splitInterval:      *splitInterval,
+				readConcurrency:    *readConcurrency,
+				outputConcurrency:  *outputConcurrency,
+				shardCount:         *shardCount,
+				shardIndex:         *shardIndex,
+				combine:            *combine,
+				metricColumn:       *metricColumn,
+				joinOn:             *joinOn,
+				joinLabels:         *joinLabels,
+				pivot:              *pivot,
+				pivotLabelPrefix:   *pivotLabelPrefix,
+				incremental:        *incremental,
+				job:                *job,
+				delay:              *delay,
+				printDebug:         *dbgOut,
+				dryRun:             *dryRun,
+				metrics:            newExportMetrics(reg, jobName),
+			})
 		}, func(error) { cancel() })
 		return nil
 	}
 }
 
+// exportJobs exports all jobs of the configuration, one after another.
+func exportJobs(ctx context.Context, logger log.Logger, reg prometheus.Registerer, b []byte, printDebug, dryRun bool) error {
+	cfg, err := parseJobsConfig(b)
+	if err != nil {
+		return err
+	}
+	for _, j := range cfg.Jobs {
+		if err := j.validateExport(); err != nil {
+			return err
+		}
+	}
+
+	for _, j := range cfg.Jobs {
+		p := j.exportParams()
+		p.printDebug = printDebug
+		p.dryRun = dryRun
+		p.metrics = newExportMetrics(reg, j.Name)
+		if err := export(ctx, log.With(logger, "job", j.Name), j.Input, j.Output, p); err != nil {
+			return errors.Wrapf(err, "job %s", j.Name)
+		}
+	}
+	return nil
+}
+
+// exportParams determines what data is exported and how.
+type exportParams struct {
+	matchers []string
+	query    string
+	step     time.Duration
+
+	mint, maxt model.TimeOrDurationValue
+	// resolutions to aggregate the series in, each exported into its own object, unless combineResolutions is set.
+	resolutions        []dataframe.Resolution
+	combineResolutions bool
+	// aggregations to export. Empty means all of them.
+	aggregations []string
+	// qualityColumns exports the expected count, maximum gap and completeness of the samples of every window.
+	qualityColumns bool
+	// emptyWindows determines how windows without samples are exported, see dataframe.EmptyWindowsMode.
+	emptyWindows dataframe.EmptyWindowsMode
+	fillLookback time.Duration
+
+	// incremental exports the time range following the last export of the job, up to now - delay.
+	incremental bool
+	job         string
+	delay       time.Duration
+
+	// splitInterval is the maximum time range of a single read. 0 means the whole time range is read at once.
+	splitInterval time.Duration
+	// readConcurrency is the maximum number of concurrent reads of sub-ranges. 1 means sub-ranges are read sequentially,
+	// streaming series without buffering them in memory.
+	readConcurrency int
+	// outputConcurrency is the maximum number of outputs aggregated and encoded concurrently.
+	outputConcurrency int
+	// shardCount and shardIndex select the shard of series to export. Sharding is disabled if shardCount <= 1.
+	shardCount, shardIndex int
+
+	// joinOn joins all selectors into a single wide dataframe on these labels, keeping also joinLabels. Disabled if empty.
+	joinOn, joinLabels []string
+	// pivot turns the values of this label into separate aggregation columns. Disabled if empty.
+	pivot string
+	// pivotLabelPrefix prefixes the pivoted columns with the label, not only with its values.
+	pivotLabelPrefix bool
+
+	// combine exports all selectors into a single dataframe instead of one dataframe per selector.
+	combine bool
+	// metricColumn is the column to store metric name at. Empty means the metric name is not exported.
+	metricColumn string
+	printDebug   bool
+	// dryRun prints the plan of the export instead of exporting the data.
+	dryRun bool
+
+	// metrics instrument the export. Not registered metrics are used if nil.
+	metrics *exportMetrics
+}
+
+// selector describes single part of the input to be read.
+type selector struct {
+	// name distinguishes the output of the selector when exporting multiple selectors separately.
+	name string
+	// match is the selector as provided by the user. Empty for PROMQL query.
+	match  string
+	params series.Params
+}
+
 func export(
 	ctx context.Context,
 	logger log.Logger,
-	matchersStr string,
 	inputConfig series.Config,
 	outputCfg exporter.Config,
-	mint, maxt model.TimeOrDurationValue,
-	resolution time.Duration,
-	printDebug bool,
+	p exportParams,
 ) error {
-	matchers, err := parser.ParseMetricSelector(matchersStr)
-	if err != nil {
-		return errors.Wrap(err, "parsing provided matchers")
+	if outputCfg.Path == "" {
+		return errors.New("output path is required")
 	}
 
 	in, err := infactory.NewSeriesReader(logger, inputConfig)
 	if err != nil {
 		return err
 	}
+	defer logerrcapture.Do(logger, in.Close, "close series reader")
 
 	exp, err := exportertfactory.NewExporter(logger, outputCfg)
 	if err != nil {
 		return err
 	}
-
-	ser, err := in.Read(ctx, series.Params{
-		Matchers: matchers,
-		MinTime:  timestamp.Time(mint.PrometheusTimestamp()),
-		MaxTime:  timestamp.Time(maxt.PrometheusTimestamp()),
+	if p.metrics == nil {
+		p.metrics = newExportMetrics(nil, "")
+	}
+	exp = exp.WithMetrics(p.metrics.exporter).WithManifest(exporter.Manifest{
+		Input:        exporter.ManifestInput{Type: string(inputConfig.Type), Endpoint: redactEndpoint(inputConfig.Endpoint)},
+		Resolution:   p.resolutionsString(),
+		Timezone:     resolutionTimezone(p.resolutions...),
+		Aggregations: p.enabledAggregations(),
 	})
+	if p.shardCount > 1 {
+		// Every shard writes its own part.
+		exp = exp.WithPath(exporter.PathWithSuffix(exp.Path(), fmt.Sprintf("shard-%d-of-%d", p.shardIndex, p.shardCount)))
+	}
+
+	if p.incremental {
+		return exportIncremental(ctx, logger, in, exp, inputConfig.Type, time.Now(), p)
+	}
+
+	mint := timestamp.Time(p.mint.PrometheusTimestamp())
+	maxt := timestamp.Time(p.maxt.PrometheusTimestamp())
+	if err := exportRange(ctx, in, exp, inputConfig.Type, mint, maxt, p); err != nil {
+		return err
+	}
+	if p.dryRun {
+		return nil
+	}
+	p.metrics.lastSuccess.SetToCurrentTime()
+	return nil
+}
+
+// exportIncremental exports the complete windows between the checkpoint of the job (or p.mint for the first export)
+// and now - delay into the object suffixed with the exported time range, then advances the checkpoint.
+// NOTE: Detection of overlapping runs of the same job is best-effort. Object storages can't update the checkpoint
+// conditionally, so runs finishing at the same time can both pass the check and export overlapping time ranges.
+// Use the schedule command, or make sure runs of the same job don't overlap otherwise.
+func exportIncremental(
+	ctx context.Context,
+	logger log.Logger,
+	in series.Reader,
+	exp *exporter.Exporter,
+	inputType series.Type,
+	now time.Time,
+	p exportParams,
+) error {
+	job := p.job
+	if job == "" {
+		job = defaultJob(exp.Path())
+	}
+	cpPath := checkpoint.Path(exp.Path(), job)
+	cp, err := checkpoint.Load(ctx, exp.Bucket(), cpPath)
+	if err != nil {
+		p.metrics.exporter.StageFailures.WithLabelValues(stageCheckpoint).Inc()
+		return err
+	}
+
+	var mint time.Time
+	switch {
+	case cp != nil:
+		mint = cp.LastSampleEnd
+	case isTimeSet(p.mint):
+		mint = p.windowStart(timestamp.Time(p.mint.PrometheusTimestamp()))
+	default:
+		return errors.Newf("no checkpoint %s found, --min-time is required for the first incremental export of job %s", cpPath, job)
+	}
+	// Export only windows that are complete.
+	maxt := p.windowStart(now.Add(-p.delay))
+	if !mint.Before(maxt) {
+		level.Info(logger).Log("msg", "no complete window to export yet", "job", job, "from", mint)
+		p.metrics.lastSuccess.SetToCurrentTime()
+		return nil
+	}
+
+	const timeFmt = "20060102T150405Z"
+	exp = exp.WithPath(exporter.PathWithSuffix(exp.Path(), mint.UTC().Format(timeFmt)+"-"+maxt.UTC().Format(timeFmt)))
+	// Time ranges are inclusive, samples at maxt belong to the next export.
+	if err := exportRange(ctx, in, exp, inputType, mint, maxt.Add(-time.Millisecond), p); err != nil {
+		return err
+	}
+	if p.dryRun {
+		return nil
+	}
+
+	// Don't move the checkpoint back when overlapping export run finished in the meantime. The checkpoint can still
+	// be moved by another run between this check and the save below.
+	current, err := checkpoint.Load(ctx, exp.Bucket(), cpPath)
+	if err != nil {
+		p.metrics.exporter.StageFailures.WithLabelValues(stageCheckpoint).Inc()
+		return err
+	}
+	if current != nil && current.LastSampleEnd.After(mint) {
+		if current.LastExportStart.Equal(mint) && current.LastSampleEnd.Equal(maxt) {
+			// The other run exported the same time range into the same objects.
+			level.Info(logger).Log("msg", "time range was exported by another run in the meantime", "job", job, "from", mint, "to", maxt)
+			return nil
+		}
+		p.metrics.exporter.StageFailures.WithLabelValues(stageCheckpoint).Inc()
+		err := errors.Newf("checkpoint %s was moved to %v by another export of job %s in the meantime", cpPath, current.LastSampleEnd, job)
+		// The exported time range overlaps with the other run, remove the objects to not duplicate the rows.
+		if derr := deleteOutputs(ctx, exp.Bucket(), exp.Path()); derr != nil {
+			return errors.Wrapf(err, "delete overlapping objects: %v", derr)
+		}
+		return err
+	}
+	if err := checkpoint.Save(ctx, exp.Bucket(), cpPath, checkpoint.Checkpoint{Job: job, LastExportStart: mint, LastSampleEnd: maxt}); err != nil {
+		p.metrics.exporter.StageFailures.WithLabelValues(stageCheckpoint).Inc()
+		return err
+	}
+	p.metrics.lastSuccess.SetToCurrentTime()
+	level.Info(logger).Log("msg", "incremental export done", "job", job, "from", mint, "to", maxt, "output", exp.Path())
+	return nil
+}
+
+// deleteOutputs removes all objects exported under the given path, including objects of separately exported
+// selectors and resolutions, their manifests and success markers.
+func deleteOutputs(ctx context.Context, bkt objstore.Bucket, p string) error {
+	dir, stem := path.Dir(p), strings.TrimSuffix(path.Base(p), path.Ext(p))
+	prefix := ""
+	if dir != "." {
+		prefix = dir + "/"
+	}
+	var names []string
+	if err := bkt.Iter(ctx, prefix, func(name string) error {
+		if base := path.Base(name); strings.HasPrefix(base, stem) || strings.HasPrefix(base, "_"+stem) {
+			names = append(names, name)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := bkt.Delete(ctx, name); err != nil && !bkt.IsObjNotFoundErr(err) {
+			return errors.Wrapf(err, "delete %s", name)
+		}
+	}
+	return nil
+}
+
+// exportRange exports the data between mint and maxt (inclusive).
+func exportRange(
+	ctx context.Context,
+	in series.Reader,
+	exp *exporter.Exporter,
+	inputType series.Type,
+	mint, maxt time.Time,
+	p exportParams,
+) error {
+	selectors, err := parseSelectors(inputType, mint, maxt, p)
+	if err != nil {
+		return err
+	}
+	if p.readConcurrency < 1 {
+		p.readConcurrency = 1
+	}
+	if p.outputConcurrency < 1 {
+		p.outputConcurrency = 1
+	}
+
+	// Align the split boundaries to the largest fixed resolution, so the windows do not straddle the sub-ranges.
+	splitInterval := p.splitInterval
+	var largest time.Duration
+	for _, res := range p.resolutions {
+		if r, ok := res.(dataframe.FixedResolution); ok && time.Duration(r) > largest {
+			largest = time.Duration(r)
+		}
+	}
+	if splitInterval > 0 && largest > 0 && splitInterval%largest != 0 {
+		splitInterval = (splitInterval/largest + 1) * largest
+	}
+
+	m := exp.Manifest()
+	m.Query, m.MinTime, m.MaxTime = p.query, mint, maxt
+
+	if p.pivot == labels.MetricName && p.metricColumn != "" {
+		return errors.Newf("--pivot %s can't be used with --metric-column, pivot the %s column instead", labels.MetricName, p.metricColumn)
+	}
+	if len(p.joinLabels) > 0 && len(p.joinOn) == 0 {
+		return errors.New("--join-label requires --join-on")
+	}
+	if len(p.joinOn) > 0 {
+		if len(selectors) < 2 || p.combine || p.metricColumn != "" {
+			return errors.New("--join-on requires multiple --match selectors, and can't be used with --combine or --metric-column")
+		}
+		if p.dryRun {
+			return errors.New("--dry-run is not supported with --join-on")
+		}
+		if p.pivot != "" {
+			// Other labels are aggregated away.
+			var found bool
+			for _, l := range p.joinBy() {
+				found = found || l == p.pivot
+			}
+			if !found {
+				return errors.New("--pivot label has to be one of --join-on or --join-label labels")
+			}
+		}
+		m.Matchers = p.matchers
+		return exportJoined(ctx, in, exp.WithManifest(m), selectors, splitInterval, mint, maxt, p)
+	}
+
+	var outputs []output
+	if p.combine || len(selectors) == 1 {
+		m.Matchers = p.matchers
+		o := output{exp: exp.WithManifest(m)}
+		for _, s := range selectors {
+			o.params = append(o.params, s.params.SplitByInterval(splitInterval)...)
+		}
+		outputs = append(outputs, o)
+	} else {
+		for _, s := range selectors {
+			m.Matchers = []string{s.match}
+			outputs = append(outputs, output{
+				name:   s.name,
+				exp:    exp.WithPath(exporter.PathWithSuffix(exp.Path(), s.name)).WithManifest(m),
+				params: s.params.SplitByInterval(splitInterval),
+			})
+		}
+	}
+
+	if p.dryRun {
+		for _, o := range outputs {
+			if err := planOutput(ctx, os.Stdout, in, o, mint, maxt, p); err != nil {
+				return errors.Wrapf(err, "plan %s", o.name)
+			}
+		}
+		return nil
+	}
+
+	// Outputs are aggregated and encoded concurrently, up to the output concurrency. Reads of all outputs share
+	// the read concurrency limit.
+	readGate := gate.New(nil, p.readConcurrency)
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(p.outputConcurrency)
+	for _, o := range outputs {
+		o := o
+		eg.Go(func() error {
+			if err := exportSet(ctx, o.exp, p.readSet(ctx, in, readGate, o.params), mint, maxt, p); err != nil {
+				return errors.Wrapf(err, "export %s", o.name)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// output is a separate dataframe exported into its own object.
+type output struct {
+	name   string
+	exp    *exporter.Exporter
+	params []series.Params
+}
+
+// parseSelectors returns selectors to read based on the input type and the export parameters.
+func parseSelectors(inputType series.Type, mint, maxt time.Time, p exportParams) ([]selector, error) {
+	base := series.Params{MinTime: mint, MaxTime: maxt}
+	if p.shardCount > 1 {
+		if p.shardIndex < 0 || p.shardIndex >= p.shardCount {
+			return nil, errors.Newf("shard index %d out of range, expected 0 to %d", p.shardIndex, p.shardCount-1)
+		}
+		base.Shard = &series.ShardInfo{Index: p.shardIndex, Count: p.shardCount}
+	}
+
+	if series.Type(strings.ToUpper(string(inputType))) == series.PROMQL {
+		if p.query == "" || len(p.matchers) > 0 {
+			return nil, errors.New("PROMQL input type requires --query flag instead of --match")
+		}
+		base.Query = p.query
+		base.Step = p.step
+		return []selector{{name: "query", params: base}}, nil
+	}
+
+	if len(p.matchers) == 0 || p.query != "" {
+		return nil, errors.Newf("%s input type requires --match flag, --query is supported only by PROMQL input type", inputType)
+	}
+
+	selectors := make([]selector, 0, len(p.matchers))
+	names := map[string]struct{}{}
+	for i, m := range p.matchers {
+		matchers, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing provided matchers %q", m)
+		}
+
+		// Name the output after the metric if possible, fallback to the position of the selector.
+		name := fmt.Sprintf("%d", i)
+		for _, lm := range matchers {
+			if lm.Name == labels.MetricName && lm.Type == labels.MatchEqual {
+				name = lm.Value
+			}
+		}
+		if _, ok := names[name]; ok {
+			name = fmt.Sprintf("%s-%d", name, i)
+		}
+		names[name] = struct{}{}
+
+		params := base
+		params.Matchers = matchers
+		selectors = append(selectors, selector{name: name, match: m, params: params})
+	}
+	return selectors, nil
+}
+
+// defaultJob returns the job name for the output object path.
+func defaultJob(outputPath string) string {
+	return strings.TrimSuffix(path.Base(outputPath), path.Ext(outputPath))
+}
+
+func isTimeSet(v model.TimeOrDurationValue) bool {
+	return v.Time != nil || v.Dur != nil
+}
+
+var debugMtx sync.Mutex
+
+// exportSet aggregates the given series read between mint and maxt into dataframe of every resolution and exports them.
+func exportSet(ctx context.Context, exp *exporter.Exporter, ser series.Set, mint, maxt time.Time, p exportParams) error {
+	dfs, seriesCount, err := aggregateSet(ser, mint, maxt, p)
 	if err != nil {
 		return err
 	}
+	return exportResolutions(ctx, exp, dfs, seriesCount, p)
+}
 
-	df, err := dataframe.FromSeries(ser, resolution, func(o *dataframe.AggrsOptions) {
-		// TODO(inecas): Expose the enabled aggregations via flag.
-		o.Count.Enabled = true
-		o.Sum.Enabled = true
-		o.Min.Enabled = true
-		o.Max.Enabled = true
+// exportJoined aggregates series of every selector by the join labels and exports them joined into a single wide dataframe.
+func exportJoined(
+	ctx context.Context,
+	in series.Reader,
+	exp *exporter.Exporter,
+	selectors []selector,
+	splitInterval time.Duration,
+	mint, maxt time.Time,
+	p exportParams,
+) error {
+	var (
+		inputs      = make([][]dataframe.JoinInput, len(p.resolutions))
+		seriesCount int
+		readGate    = gate.New(nil, p.readConcurrency)
+	)
+	for _, s := range selectors {
+		dfs, n, err := aggregateSet(p.readSet(ctx, in, readGate, s.params.SplitByInterval(splitInterval)), mint, maxt, p)
+		if err != nil {
+			return errors.Wrapf(err, "export %s", s.name)
+		}
+		seriesCount += n
+		for i, df := range dfs {
+			inputs[i] = append(inputs[i], dataframe.JoinInput{Name: s.name, Dataframe: df})
+		}
+	}
+
+	joined := make([]dataframe.Dataframe, 0, len(inputs))
+	for _, in := range inputs {
+		df, err := dataframe.Join(p.joinOn, in...)
+		if err != nil {
+			return errors.Wrap(err, "join")
+		}
+		joined = append(joined, df)
+	}
+	return exportResolutions(ctx, exp, joined, seriesCount, p)
+}
+
+// aggregateSet aggregates the given series read between mint and maxt into dataframe of every resolution.
+// Returns also the number of distinct series.
+func aggregateSet(ser series.Set, mint, maxt time.Time, p exportParams) ([]dataframe.Dataframe, int, error) {
+	start := time.Now()
+	cs := &countingSet{Set: ser, series: p.metrics.series, distinct: map[uint64]struct{}{}}
+	dfs, err := dataframe.FromSeriesResolutions(cs, p.resolutions, aggrOptions(p), func(o *dataframe.AggrsOptions) {
+		o.EmptyWindows = dataframe.EmptyWindowsOption{Mode: p.emptyWindows, Lookback: p.fillLookback, MinTime: mint, MaxTime: maxt}
 	})
 	if err != nil {
-		return errors.Wrap(err, "dataframe creation")
+		p.metrics.exporter.StageFailures.WithLabelValues(stageRead).Inc()
+		return nil, 0, errors.Wrap(err, "dataframe creation")
 	}
+	p.metrics.exporter.StageDuration.WithLabelValues(stageRead).Observe(time.Since(start).Seconds())
+	return dfs, len(cs.distinct), nil
+}
 
-	if printDebug {
+// exportResolutions exports the dataframes of every resolution, into their own objects unless combined.
+func exportResolutions(ctx context.Context, exp *exporter.Exporter, dfs []dataframe.Dataframe, seriesCount int, p exportParams) error {
+	m := exp.Manifest()
+	m.Series = seriesCount
+	if len(dfs) > 1 && p.combineResolutions {
+		df, err := dataframe.Concat(dfs...)
+		if err != nil {
+			return err
+		}
+		return exportDataframe(ctx, exp.WithManifest(m), df, p)
+	}
+	for i, df := range dfs {
+		e := exp
+		if len(dfs) > 1 {
+			m.Resolution, m.Timezone = p.resolutions[i].String(), resolutionTimezone(p.resolutions[i])
+			e = exp.WithPath(resolutionPath(exp.Path(), p.resolutions[i]))
+		}
+		if err := exportDataframe(ctx, e.WithManifest(m), df, p); err != nil {
+			return errors.Wrapf(err, "resolution %s", p.resolutions[i])
+		}
+	}
+	return nil
+}
+
+// exportDataframe exports the aggregated dataframe.
+func exportDataframe(ctx context.Context, exp *exporter.Exporter, df dataframe.Dataframe, p exportParams) error {
+	if p.pivot != "" {
+		pivoted, err := dataframe.Pivot(df, p.pivot, p.pivotOptions)
+		if err != nil {
+			return errors.Wrap(err, "pivot")
+		}
+		df = pivoted
+	}
+	if p.printDebug {
+		// Outputs can be exported concurrently, don't interleave their tables.
+		debugMtx.Lock()
 		dataframe.Print(os.Stdout, df)
+		debugMtx.Unlock()
 	}
 
 	if err := exp.Export(ctx, df); err != nil {
@@ -134,3 +735,234 @@ func export(
 	}
 	return nil
 }
+
+// resolutionPath returns the path of the object of the resolution, when multiple resolutions are exported separately.
+func resolutionPath(p string, res dataframe.Resolution) string {
+	return exporter.PathWithSuffix(p, res.String())
+}
+
+// outputPaths returns the paths of the objects the output is exported to.
+func (p exportParams) outputPaths(exp *exporter.Exporter) []string {
+	if len(p.resolutions) == 1 || p.combineResolutions {
+		return []string{exp.Path()}
+	}
+	paths := make([]string, 0, len(p.resolutions))
+	for _, res := range p.resolutions {
+		paths = append(paths, resolutionPath(exp.Path(), res))
+	}
+	return paths
+}
+
+// windowStart returns the earliest start of the windows containing t across all resolutions. With nested resolutions
+// (see validateNestedResolutions), it is the start of the window of the coarsest one and starts windows of all others.
+func (p exportParams) windowStart(t time.Time) time.Time {
+	start := t
+	for _, res := range p.resolutions {
+		if s := res.WindowStart(t); s.Before(start) {
+			start = s
+		}
+	}
+	return start
+}
+
+// resolutionsString returns the comma separated list of the resolutions.
+func (p exportParams) resolutionsString() string {
+	s := make([]string, 0, len(p.resolutions))
+	for _, res := range p.resolutions {
+		s = append(s, res.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// countWindows returns the number of windows of the resolution between mint and maxt (inclusive).
+func countWindows(res dataframe.Resolution, mint, maxt time.Time) int64 {
+	if r, ok := res.(dataframe.FixedResolution); ok {
+		return int64(maxt.Sub(r.WindowStart(mint))/time.Duration(r)) + 1
+	}
+	var n int64
+	for start := res.WindowStart(mint); !start.After(maxt); start = res.WindowEnd(start) {
+		n++
+	}
+	return n
+}
+
+// joinBy returns the labels to aggregate the series by when joining them.
+func (p exportParams) joinBy() []string {
+	return append(append([]string{}, p.joinOn...), p.joinLabels...)
+}
+
+// readSet returns the series read for all params, one after another. With read concurrency greater than 1, reads
+// are issued ahead of the iteration, which consumes them in order, so the result is deterministic.
+func (p exportParams) readSet(ctx context.Context, in series.Reader, g gate.Gate, params []series.Params) series.Set {
+	if p.readConcurrency <= 1 {
+		return series.ReadChained(ctx, in, params...)
+	}
+	return series.ReadAhead(ctx, in, g, p.readConcurrency, params...)
+}
+
+// pivotOptions sets the options of the pivot.
+func (p exportParams) pivotOptions(o *dataframe.PivotOptions) {
+	o.LabelPrefix = p.pivotLabelPrefix
+}
+
+// enabledAggregations returns the aggregations to export.
+func (p exportParams) enabledAggregations() []string {
+	if len(p.aggregations) == 0 {
+		return aggregations
+	}
+	return p.aggregations
+}
+
+// redactEndpoint hides the password of the endpoint URL, if any.
+func redactEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.User == nil {
+		return endpoint
+	}
+	return u.Redacted()
+}
+
+// aggrOptions returns the dataframe options for the export parameters.
+func aggrOptions(p exportParams) dataframe.AggrOptionFunc {
+	return func(o *dataframe.AggrsOptions) {
+		for _, a := range p.enabledAggregations() {
+			switch a {
+			case aggrCount:
+				o.Count.Enabled = true
+			case aggrSum:
+				o.Sum.Enabled = true
+			case aggrMin:
+				o.Min.Enabled = true
+			case aggrMax:
+				o.Max.Enabled = true
+			}
+		}
+
+		if p.qualityColumns {
+			o.ExpectedCount.Enabled = true
+			o.MaxGap.Enabled = true
+			o.Completeness.Enabled = true
+		}
+		if p.combineResolutions && len(p.resolutions) > 1 {
+			o.Resolution.Enabled = true
+		}
+		if len(p.joinOn) > 0 {
+			o.By = p.joinBy()
+		}
+		// Keep track of metrics the rows belong to when combining multiple selectors.
+		if p.combine && len(p.matchers) > 1 {
+			o.MetricName.Enabled = true
+		}
+		if p.pivot == labels.MetricName {
+			o.MetricName.Enabled = true
+		}
+		if p.metricColumn != "" {
+			o.MetricName.Enabled = true
+			o.MetricName.Column = p.metricColumn
+		}
+	}
+}
+
+// planOutput lists the series of the output without reading their samples and prints what would be exported.
+// The number of rows is estimated as if every series had samples in every window of the time range.
+func planOutput(ctx context.Context, w io.Writer, in series.Reader, o output, mint, maxt time.Time, p exportParams) error {
+	var (
+		seen       = map[uint64]struct{}{}
+		labelNames = map[string]struct{}{}
+		// Rows of pivoted series and values of the pivoted label.
+		rowSeries   = map[uint64]struct{}{}
+		pivotValues = map[string]struct{}{}
+		pivotLabel  = p.pivot
+	)
+	if p.pivot != "" && p.pivot == p.metricColumn {
+		pivotLabel = labels.MetricName
+	}
+	for _, params := range o.params {
+		set, err := series.ListSeries(ctx, in, params)
+		if err != nil {
+			return err
+		}
+		for set.Next() {
+			ls := set.At().Labels()
+			// The same series can be listed in multiple sub-ranges.
+			seen[ls.Hash()] = struct{}{}
+			for _, l := range ls {
+				labelNames[l.Name] = struct{}{}
+			}
+			if p.pivot != "" {
+				rowSeries[labels.NewBuilder(ls).Del(pivotLabel).Labels(nil).Hash()] = struct{}{}
+				if v := ls.Get(pivotLabel); v != "" {
+					pivotValues[v] = struct{}{}
+				}
+			}
+		}
+		if err := set.Err(); err != nil {
+			_ = set.Close()
+			return err
+		}
+		if err := set.Close(); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(labelNames))
+	for l := range labelNames {
+		names = append(names, l)
+	}
+	schema, err := dataframe.SeriesSchema(names, aggrOptions(p))
+	if err != nil {
+		return err
+	}
+	rows := int64(len(seen))
+	if p.pivot != "" {
+		if schema, err = pivotSchema(schema, p.pivot, pivotValues, p.pivotOptions); err != nil {
+			return errors.Wrap(err, "pivot")
+		}
+		rows = int64(len(rowSeries))
+	}
+	columns := make([]string, 0, len(schema))
+	for _, c := range schema {
+		columns = append(columns, c.Name)
+	}
+
+	var (
+		windows int64
+		details = make([]string, 0, len(p.resolutions))
+	)
+	for _, res := range p.resolutions {
+		n := countWindows(res, mint, maxt)
+		windows += n
+		details = append(details, fmt.Sprintf("%d windows of %s", n, res))
+	}
+	fmt.Fprintf(w, "output: %s\n", strings.Join(p.outputPaths(o.exp), ", "))
+	fmt.Fprintf(w, "series: %d\n", len(seen))
+	fmt.Fprintf(w, "columns: %s\n", strings.Join(columns, ", "))
+	fmt.Fprintf(w, "estimated rows: %d (%s per series at most)\n", rows*windows, strings.Join(details, ", "))
+	return nil
+}
+
+// pivotSchema returns the schema of the dataframe pivoted on the column with the given values.
+func pivotSchema(schema dataframe.Schema, column string, values map[string]struct{}, opts func(o *dataframe.PivotOptions)) (dataframe.Schema, error) {
+	idx := -1
+	for i, c := range schema {
+		if c.Name == column {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, errors.Newf("pivoted column %q not found", column)
+	}
+
+	// Pivot a row of every value, the same way as the aggregated dataframe.
+	rows := make([]dataframe.Row, 0, len(values))
+	for v := range values {
+		row := make(dataframe.Row, len(schema))
+		row[idx] = v
+		rows = append(rows, row)
+	}
+	df, err := dataframe.Pivot(dataframe.FromRows(schema, rows), column, opts)
+	if err != nil {
+		return nil, err
+	}
+	return df.Schema(), nil
+}
@@ -19,11 +19,11 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/objstore/client"
 	"github.com/thanos-io/objstore/providers/filesystem"
-	"github.com/thanos-io/thanos/pkg/model"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"google.golang.org/grpc"
 
+	"github.com/thanos-community/obslytics/pkg/dataframe"
 	"github.com/thanos-community/obslytics/pkg/exporter"
 	"github.com/thanos-community/obslytics/pkg/series"
 )
@@ -96,9 +96,8 @@ func benchExport(b *testing.B, resp []*storepb.SeriesResponse) {
 	}()
 
 	var (
-		ctx      = context.Background()
-		logger   = log.NewNopLogger()
-		matchers = "{something=\"doesnotmatter\"}"
+		ctx    = context.Background()
+		logger = log.NewNopLogger()
 	)
 
 	b.ReportAllocs()
@@ -107,12 +106,12 @@ func benchExport(b *testing.B, resp []*storepb.SeriesResponse) {
 		testutil.Ok(b, export(
 			ctx,
 			logger,
-			matchers,
 			series.Config{
 				Type:     series.STOREAPI,
 				Endpoint: list.Addr().String(),
 			}, exporter.Config{
 				Type: exporter.PARQUET,
+				Path: "out.parquet",
 				Storage: client.BucketConfig{
 					Type: client.FILESYSTEM,
 					Config: filesystem.Config{
@@ -120,10 +119,10 @@ func benchExport(b *testing.B, resp []*storepb.SeriesResponse) {
 					},
 				},
 			},
-			model.TimeOrDurationValue{},
-			model.TimeOrDurationValue{},
-			5*time.Minute,
-			false,
+			exportParams{
+				matchers:    []string{"{something=\"doesnotmatter\"}"},
+				resolutions: []dataframe.Resolution{dataframe.FixedResolution(5 * time.Minute)},
+			},
 		))
 	}
 
@@ -137,6 +136,9 @@ type sample struct {
 	v float64
 }
 
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
 type testThanosSeriesServer struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
 	storepb.StoreServer
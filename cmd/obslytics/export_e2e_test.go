@@ -75,7 +75,8 @@ rule_files:
 	return config
 }
 
-func exportToParquet(t *testing.T, ctx context.Context, r series.Reader, bkt objstore.Bucket, mint, maxt time.Time, fileName string) {
+// exportToParquet exports the series into the parquet file and returns the exported dataframe printed.
+func exportToParquet(t *testing.T, ctx context.Context, r series.Reader, bkt objstore.Bucket, mint, maxt time.Time, fileName string) string {
 	s, err := r.Read(ctx, series.Params{
 		Matchers: []*labels.Matcher{
 			labels.MustNewMatcher(labels.MatchEqual, "__name__", "prometheus_tsdb_head_series"),
@@ -85,7 +86,7 @@ func exportToParquet(t *testing.T, ctx context.Context, r series.Reader, bkt obj
 	})
 	testutil.Ok(t, err)
 
-	df, err := dataframe.FromSeries(s, 3*time.Second, func(o *dataframe.AggrsOptions) {
+	df, err := dataframe.FromSeries(s, dataframe.FixedResolution(3*time.Second), func(o *dataframe.AggrsOptions) {
 		// TODO(inecas): Expose the enabled aggregations via flag.
 		o.Count.Enabled = true
 		o.Sum.Enabled = true
@@ -96,6 +97,7 @@ func exportToParquet(t *testing.T, ctx context.Context, r series.Reader, bkt obj
 
 	t.Log("Dataframe:", dataframe.ToString(df))
 	testutil.Ok(t, exporter.New(parquet.NewEncoder(), fileName, bkt).Export(ctx, df))
+	return dataframe.ToString(df)
 }
 
 func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
@@ -116,6 +118,7 @@ func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
 
 	logger := log.NewLogfmtLogger(os.Stderr)
 	bkt := objstore.NewInMemBucket()
+	var exported1, exported2 string
 
 	t.Run("export metric from RemoteRead to parquet file", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
@@ -127,7 +130,7 @@ func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
 		})
 		testutil.Ok(t, err)
 
-		exportToParquet(t, ctx, api, bkt, mint, maxt, "something/yolo.parquet")
+		exported1 = exportToParquet(t, ctx, api, bkt, mint, maxt, "something/yolo.parquet")
 	})
 
 	t.Run("export metric from StoreAPI to parquet file", func(t *testing.T) {
@@ -140,29 +143,31 @@ func TestRemoteReadAndThanos_Parquet_e2e(t *testing.T) {
 		})
 		testutil.Ok(t, err)
 
-		exportToParquet(t, ctx, api, bkt, mint, maxt, "something/yolo2.parquet")
+		exported2 = exportToParquet(t, ctx, api, bkt, mint, maxt, "something/yolo2.parquet")
 	})
 
-	result, err := bkt.Get(context.Background(), "something/yolo.parquet")
+	// Files have to contain what was exported.
+	df1, err := exporter.Read(context.Background(), bkt, "something/yolo.parquet", parquet.NewDecoder())
+	testutil.Ok(t, err)
+	testutil.Assert(t, dataframe.Rows(df1).Next(), "expected exported rows")
+	testutil.Equals(t, exported1, dataframe.ToString(df1))
+
+	df2, err := exporter.Read(context.Background(), bkt, "something/yolo2.parquet", parquet.NewDecoder())
 	testutil.Ok(t, err)
+	testutil.Equals(t, exported2, dataframe.ToString(df2))
 
+	result, err := bkt.Get(context.Background(), "something/yolo.parquet")
+	testutil.Ok(t, err)
 	resultBytes1, err := io.ReadAll(result)
 	testutil.Ok(t, err)
 	testutil.Ok(t, result.Close())
 
-	// TODO(bwplotka): Assert properly the actual result, vs what metric actually gives.
-	testutil.Assert(t, 1610 <= len(resultBytes1)) // Output varies from 2197 to 1610, debug shows me sometimes two rows, is this expected?
-
 	result, err = bkt.Get(context.Background(), "something/yolo2.parquet")
 	testutil.Ok(t, err)
-
 	resultBytes2, err := io.ReadAll(result)
 	testutil.Ok(t, err)
 	testutil.Ok(t, result.Close())
 
-	// TODO(bwplotka): Assert properly the actual result, vs what metric actually gives.
-	testutil.Assert(t, 1610 <= len(resultBytes2)) // Output varies from 2197 to 1610, debug shows me sometimes two rows, is this expected?
-
 	// Data from both StoreAPI and Remote Read should be the same.
 	testutil.Equals(t, resultBytes1, resultBytes2)
 }
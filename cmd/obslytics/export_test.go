@@ -0,0 +1,202 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-community/obslytics/pkg/checkpoint"
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/exporter/parquet"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// listingReader lists the given series for every read. Reading samples fails.
+type listingReader struct {
+	series []labels.Labels
+}
+
+func (r listingReader) Read(context.Context, series.Params) (series.Set, error) {
+	panic("samples should not be read")
+}
+
+func (r listingReader) ListSeries(context.Context, series.Params) (series.Set, error) {
+	ss := make([]storage.Series, 0, len(r.series))
+	for _, ls := range r.series {
+		ss = append(ss, storage.NewListSeries(ls, nil))
+	}
+	return series.NewListSet(ss...), nil
+}
+
+func (r listingReader) Close() error { return nil }
+
+// hourlyReader reads a single series with a sample 10 minutes after every full hour of the requested time range,
+// calling onRead first if set.
+type hourlyReader struct {
+	onRead func()
+}
+
+func (r hourlyReader) Read(_ context.Context, params series.Params) (series.Set, error) {
+	if r.onRead != nil {
+		r.onRead()
+	}
+	var samples []tsdbutil.Sample
+	for t := params.MinTime.Truncate(time.Hour).Add(10 * time.Minute); !t.After(params.MaxTime); t = t.Add(time.Hour) {
+		if !t.Before(params.MinTime) {
+			samples = append(samples, sample{t: timestamp.FromTime(t), v: 1})
+		}
+	}
+	return series.NewListSet(storage.NewListSeries(labels.FromStrings(labels.MetricName, "up"), samples)), nil
+}
+
+func (r hourlyReader) Close() error { return nil }
+
+func TestExportIncremental(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	exp := exporter.New(parquet.NewEncoder(), "dir/out.parquet", bkt)
+	p := exportParams{
+		matchers:    []string{"up"},
+		resolutions: []dataframe.Resolution{dataframe.FixedResolution(time.Hour)},
+		incremental: true,
+		job:         "up",
+		delay:       5 * time.Minute,
+		metrics:     newExportMetrics(nil, ""),
+	}
+	testutil.Ok(t, p.mint.Set("2020-01-01T00:30:00Z"))
+	at := func(hour, min int) time.Time { return time.Date(2020, 1, 1, hour, min, 0, 0, time.UTC) }
+	cpPath := checkpoint.Path(exp.Path(), "up")
+
+	exported := func(t *testing.T, name string) int64 {
+		t.Helper()
+		m, err := exporter.ReadManifest(ctx, bkt, name)
+		testutil.Ok(t, err)
+		testutil.Assert(t, m != nil, "%s not exported", name)
+		return m.Rows
+	}
+	lastSampleEnd := func(t *testing.T) time.Time {
+		t.Helper()
+		cp, err := checkpoint.Load(ctx, bkt, cpPath)
+		testutil.Ok(t, err)
+		return cp.LastSampleEnd
+	}
+
+	// The first export starts at the window of --min-time, and ends with the last complete window before now - delay.
+	testutil.Ok(t, exportIncremental(ctx, log.NewNopLogger(), hourlyReader{}, exp, series.STOREAPI, at(3, 2), p))
+	testutil.Equals(t, int64(2), exported(t, "dir/out-20200101T000000Z-20200101T020000Z.parquet"))
+	testutil.Equals(t, at(2, 0), lastSampleEnd(t))
+
+	// No complete window yet.
+	testutil.Ok(t, exportIncremental(ctx, log.NewNopLogger(), hourlyReader{}, exp, series.STOREAPI, at(3, 4), p))
+	testutil.Equals(t, at(2, 0), lastSampleEnd(t))
+
+	// Next exports continue from the checkpoint.
+	testutil.Ok(t, exportIncremental(ctx, log.NewNopLogger(), hourlyReader{}, exp, series.STOREAPI, at(4, 10), p))
+	testutil.Equals(t, int64(2), exported(t, "dir/out-20200101T020000Z-20200101T040000Z.parquet"))
+	testutil.Equals(t, at(4, 0), lastSampleEnd(t))
+
+	t.Run("overlapping run", func(t *testing.T) {
+		// Another run exports the first hour and moves the checkpoint while this one exports two hours.
+		other := hourlyReader{onRead: func() {
+			testutil.Ok(t, checkpoint.Save(ctx, bkt, cpPath, checkpoint.Checkpoint{Job: "up", LastExportStart: at(4, 0), LastSampleEnd: at(5, 0)}))
+		}}
+		testutil.NotOk(t, exportIncremental(ctx, log.NewNopLogger(), other, exp, series.STOREAPI, at(6, 10), p))
+		// The objects of the losing run are removed, so the rows are not duplicated.
+		m, err := exporter.ReadManifest(ctx, bkt, "dir/out-20200101T040000Z-20200101T060000Z.parquet")
+		testutil.Ok(t, err)
+		testutil.Assert(t, m == nil, "overlapping object left")
+		testutil.Equals(t, at(5, 0), lastSampleEnd(t))
+
+		// Another run exporting the same time range writes the same objects.
+		same := hourlyReader{onRead: func() {
+			testutil.Ok(t, checkpoint.Save(ctx, bkt, cpPath, checkpoint.Checkpoint{Job: "up", LastExportStart: at(5, 0), LastSampleEnd: at(6, 0)}))
+		}}
+		testutil.Ok(t, exportIncremental(ctx, log.NewNopLogger(), same, exp, series.STOREAPI, at(6, 10), p))
+		testutil.Equals(t, int64(1), exported(t, "dir/out-20200101T050000Z-20200101T060000Z.parquet"))
+		testutil.Equals(t, at(6, 0), lastSampleEnd(t))
+	})
+}
+
+func TestPlanOutput(t *testing.T) {
+	in := listingReader{series: []labels.Labels{
+		labels.FromStrings(labels.MetricName, "up", "job", "a"),
+		labels.FromStrings(labels.MetricName, "up", "job", "b", "instance", "1"),
+	}}
+	mint := time.Date(2020, 1, 1, 0, 2, 0, 0, time.UTC)
+	maxt := mint.Add(time.Hour)
+	p := exportParams{resolutions: []dataframe.Resolution{dataframe.FixedResolution(5 * time.Minute)}, metricColumn: "metric"}
+	o := output{
+		exp: exporter.New(nil, "out.parquet", nil),
+		// Series listed in both sub-ranges are counted once.
+		params: series.Params{MinTime: mint, MaxTime: maxt}.SplitByInterval(30 * time.Minute),
+	}
+
+	b := &bytes.Buffer{}
+	// Listing is forwarded by the retrying reader.
+	testutil.Ok(t, planOutput(context.Background(), b, series.NewRetryingReader(log.NewNopLogger(), in, series.Config{Retries: 1}), o, mint, maxt, p))
+	testutil.Equals(t, `output: out.parquet
+series: 2
+columns: metric, instance, job, _sample_start, _sample_end, _min_time, _max_time, _count, _sum, _min, _max
+estimated rows: 26 (13 windows of 5m per series at most)
+`, b.String())
+
+	// Every resolution is exported into its own object, unless combined.
+	p.resolutions = append(p.resolutions, dataframe.FixedResolution(time.Hour))
+	b.Reset()
+	testutil.Ok(t, planOutput(context.Background(), b, in, o, mint, maxt, p))
+	testutil.Equals(t, `output: out-5m.parquet, out-1h.parquet
+series: 2
+columns: metric, instance, job, _sample_start, _sample_end, _min_time, _max_time, _count, _sum, _min, _max
+estimated rows: 30 (13 windows of 5m, 2 windows of 1h per series at most)
+`, b.String())
+
+	p.combineResolutions = true
+	b.Reset()
+	testutil.Ok(t, planOutput(context.Background(), b, in, o, mint, maxt, p))
+	testutil.Equals(t, `output: out.parquet
+series: 2
+columns: metric, instance, job, _resolution, _sample_start, _sample_end, _min_time, _max_time, _count, _sum, _min, _max
+estimated rows: 30 (13 windows of 5m, 2 windows of 1h per series at most)
+`, b.String())
+
+	// Aggregations of every job are pivoted into their own columns, rows are estimated from series without the job label.
+	p = exportParams{resolutions: []dataframe.Resolution{dataframe.FixedResolution(5 * time.Minute)}, aggregations: []string{aggrSum}, pivot: "job"}
+	b.Reset()
+	testutil.Ok(t, planOutput(context.Background(), b, in, o, mint, maxt, p))
+	testutil.Equals(t, `output: out.parquet
+series: 2
+columns: instance, _sample_start, _sample_end, a_min_time, a_max_time, a_sum, b_min_time, b_max_time, b_sum
+estimated rows: 26 (13 windows of 5m per series at most)
+`, b.String())
+
+	p.pivotLabelPrefix = true
+	b.Reset()
+	testutil.Ok(t, planOutput(context.Background(), b, in, o, mint, maxt, p))
+	testutil.Equals(t, `output: out.parquet
+series: 2
+columns: instance, _sample_start, _sample_end, job_a_min_time, job_a_max_time, job_a_sum, job_b_min_time, job_b_max_time, job_b_sum
+estimated rows: 26 (13 windows of 5m per series at most)
+`, b.String())
+
+	// Metrics are pivoted by the metric column, when exported.
+	p.pivot, p.metricColumn, p.matchers = labels.MetricName, "metric", []string{"up"}
+	testutil.NotOk(t, exportRange(context.Background(), in, o.exp, series.STOREAPI, mint, maxt, p))
+
+	// Readers not implementing listing are not supported.
+	err := planOutput(context.Background(), b, struct{ series.Reader }{in}, o, mint, maxt, p)
+	testutil.Assert(t, errors.Is(err, series.ErrListingNotSupported))
+}
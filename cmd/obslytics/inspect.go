@@ -0,0 +1,92 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/go-kit/log"
+	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore/client"
+	"gopkg.in/yaml.v2"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	exportertfactory "github.com/thanos-community/obslytics/pkg/exporter/factory"
+)
+
+func registerInspect(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("inspect", "Print the schema, number of rows, time range and first rows of an exported object.")
+	objPath := cmd.Arg("path", "Path of the exported object in the output bucket.").Required().String()
+	outputFlag := extflag.RegisterPathOrContent(cmd, "output-config", "YAML for dataframe export configuration, determining the bucket and the format of the object. "+
+		"The path option is ignored.")
+	limit := cmd.Flag("limit", "Number of rows to print.").Default("10").Int()
+
+	m["inspect"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			outputCfg, err := outputFlag.Content()
+			if err != nil {
+				return err
+			}
+
+			outputConfig := exporter.Config{
+				// Default Storage Type is Filesystem.
+				Storage: client.BucketConfig{Type: client.FILESYSTEM},
+			}
+			if err := yaml.UnmarshalStrict(outputCfg, &outputConfig); err != nil {
+				return err
+			}
+			return inspect(ctx, logger, os.Stdout, outputConfig, *objPath, *limit)
+		}, func(error) { cancel() })
+		return nil
+	}
+}
+
+// inspect reads the summary of the exported object and prints it together with the first limit rows. The object is
+// not read as a whole, only its metadata and the printed rows are.
+func inspect(ctx context.Context, logger log.Logger, w io.Writer, outputCfg exporter.Config, path string, limit int) error {
+	exp, err := exportertfactory.NewExporter(logger, outputCfg)
+	if err != nil {
+		return err
+	}
+	dec, err := exportertfactory.NewDecoder(outputCfg.Type)
+	if err != nil {
+		return err
+	}
+	summarizer, ok := dec.(exporter.Summarizer)
+	if !ok {
+		return errors.Newf("inspecting %s objects is not supported", outputCfg.Type)
+	}
+	s, err := summarizer.Summarize(ctx, exp.Bucket(), path, limit)
+	if err != nil {
+		return errors.Wrapf(err, "summarize %s", path)
+	}
+
+	fmt.Fprintln(w, "schema:")
+	for _, c := range s.Schema {
+		if c.Required {
+			fmt.Fprintf(w, "  %s: %s, required\n", c.Name, c.Type)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s\n", c.Name, c.Type)
+	}
+	fmt.Fprintf(w, "rows: %d\n", s.Rows)
+	if !s.MinTime.IsZero() {
+		fmt.Fprintf(w, "time range: %s - %s\n", s.MinTime.Format(time.RFC3339), s.MaxTime.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
+	dataframe.Print(w, s.Head)
+	return nil
+}
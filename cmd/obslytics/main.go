@@ -10,12 +10,19 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	// Embed the timezone database, so --timezone works without it being installed.
+	_ "time/tzdata"
 
 	"github.com/efficientgo/core/errors"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/common/version"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/prober"
+	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"go.uber.org/automaxprocs/maxprocs"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -25,7 +32,12 @@ const (
 	logFormatJson   = "json"
 )
 
-type setupFunc func(*run.Group, log.Logger) error
+type setupFunc func(*run.Group, log.Logger, *prometheus.Registry) error
+
+// component identifies obslytics in the HTTP server logs and status metrics.
+type component string
+
+func (c component) String() string { return string(c) }
 
 func main() {
 	if os.Getenv("DEBUG") != "" {
@@ -41,9 +53,17 @@ func main() {
 		Default("info").Enum("error", "warn", "info", "debug")
 	logFormat := app.Flag("log.format", "Log format to use.").
 		Default(logFormatLogfmt).Enum(logFormatLogfmt, logFormatJson)
+	httpAddr := app.Flag("http-address", "Listen host:port for HTTP endpoints serving /metrics, /-/healthy and /-/ready. Disabled if empty.").
+		Default("").String()
+	httpGracePeriod := app.Flag("http-grace-period", "Time to wait after an interrupt received for HTTP Server.").
+		Default("2m").Duration()
 
 	cmds := map[string]setupFunc{}
 	registerExport(cmds, app)
+	registerSchedule(cmds, app)
+	registerInspect(cmds, app)
+	registerSeries(cmds, app)
+	registerLabels(cmds, app)
 
 	cmd, err := app.Parse(os.Args[1:])
 	if err != nil {
@@ -87,12 +107,41 @@ func main() {
 		level.Warn(logger).Log("msg", "failed to set GOMAXPROCS", "err", err)
 	}
 
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		version.NewCollector("obslytics"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
 	var g run.Group
-	if err := cmds[cmd](&g, logger); err != nil {
+	if err := cmds[cmd](&g, logger, reg); err != nil {
 		level.Error(logger).Log("err", fmt.Sprintf("%v", errors.Wrapf(err, "%s command failed", cmd)))
 		os.Exit(1)
 	}
 
+	if *httpAddr != "" {
+		comp := component("obslytics")
+		httpProbe := prober.NewHTTP()
+		statusProber := prober.Combine(
+			httpProbe,
+			prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("obslytics_", reg)),
+		)
+		srv := httpserver.New(logger, reg, comp, httpProbe,
+			httpserver.WithListen(*httpAddr),
+			httpserver.WithGracePeriod(*httpGracePeriod),
+		)
+		g.Add(func() error {
+			statusProber.Healthy()
+			statusProber.Ready()
+			return srv.ListenAndServe()
+		}, func(err error) {
+			statusProber.NotReady(err)
+			defer statusProber.NotHealthy(err)
+			srv.Shutdown(err)
+		})
+	}
+
 	// Listen for termination signals.
 	{
 		cancel := make(chan struct{})
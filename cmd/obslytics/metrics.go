@@ -0,0 +1,81 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/thanos-community/obslytics/pkg/exporter"
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+const (
+	// stageRead covers reading the series and aggregating them into the dataframe.
+	stageRead       = "read"
+	stageCheckpoint = "checkpoint"
+)
+
+// exportMetrics instruments exports of a single job.
+type exportMetrics struct {
+	series      prometheus.Counter
+	lastSuccess prometheus.Gauge
+
+	exporter *exporter.Metrics
+}
+
+// newExportMetrics creates metrics of the job. Metrics are not registered if reg is nil.
+func newExportMetrics(reg prometheus.Registerer, job string) *exportMetrics {
+	if reg != nil {
+		reg = prometheus.WrapRegistererWith(prometheus.Labels{"export_job": job}, reg)
+	}
+	return &exportMetrics{
+		series: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "obslytics_export_series_total",
+			Help: "Total number of series read from the input. Series read in multiple sub-ranges are counted once per sub-range.",
+		}),
+		lastSuccess: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "obslytics_export_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful export.",
+		}),
+		exporter: &exporter.Metrics{
+			Rows: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+				Name: "obslytics_export_rows_total",
+				Help: "Total number of exported rows.",
+			}),
+			UploadedBytes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+				Name: "obslytics_export_uploaded_bytes_total",
+				Help: "Total number of bytes uploaded to the output object storage.",
+			}),
+			StageDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "obslytics_export_stage_duration_seconds",
+				Help:    "Duration of the export stages: read (including aggregation), encode and upload.",
+				Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+			}, []string{"stage"}),
+			StageFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "obslytics_export_stage_failures_total",
+				Help: "Total number of export failures by stage: read, encode, upload or checkpoint.",
+			}, []string{"stage"}),
+		},
+	}
+}
+
+// countingSet counts the series iterated over.
+type countingSet struct {
+	series.Set
+	series prometheus.Counter
+	// distinct records hashes of the series, as the same series can be returned by multiple sub-range reads.
+	distinct map[uint64]struct{}
+}
+
+func (s *countingSet) Next() bool {
+	if !s.Set.Next() {
+		return false
+	}
+	s.series.Inc()
+	if s.distinct != nil {
+		s.distinct[s.At().Labels().Hash()] = struct{}{}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func registerSchedule(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("schedule", "Periodically run incremental exports of the configured jobs (see export --incremental).")
+	jobsFlag := extflag.RegisterPathOrContent(cmd, "jobs-config", "YAML with the list of export jobs, the same as export --config. Every job requires "+
+		"interval and optionally jitter. Jobs are always exported incrementally, max_time is not allowed. Runs of a job never overlap, "+
+		"an export is skipped while the previous one is still running.", extflag.WithRequired())
+	shutdownTimeout := cmd.Flag("shutdown-timeout", "Time to wait for the running exports to finish on shutdown before canceling them.").Default("1m").Duration()
+
+	m["schedule"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry) error {
+		b, err := jobsFlag.Content()
+		if err != nil {
+			return err
+		}
+		cfg, err := parseJobsConfig(b)
+		if err != nil {
+			return err
+		}
+
+		for _, j := range cfg.Jobs {
+			if err := j.validateSchedule(); err != nil {
+				return err
+			}
+
+			s := &scheduledJob{
+				logger:          log.With(logger, "job", j.Name),
+				conf:            j,
+				metrics:         newExportMetrics(reg, j.Name),
+				shutdownTimeout: *shutdownTimeout,
+			}
+			stop := make(chan struct{})
+			g.Add(func() error {
+				s.run(stop)
+				return nil
+			}, func(error) {
+				close(stop)
+			})
+		}
+		return nil
+	}
+}
+
+// scheduledJob runs incremental exports of a single job every interval.
+type scheduledJob struct {
+	logger          log.Logger
+	conf            jobConfig
+	metrics         *exportMetrics
+	shutdownTimeout time.Duration
+}
+
+// run exports the job every interval until stop is closed. On stop, it waits up to the shutdown timeout for the running
+// exports to finish, and cancels them afterwards.
+func (s *scheduledJob) run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer func() {
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return
+		case <-time.After(s.shutdownTimeout):
+			level.Warn(s.logger).Log("msg", "running exports did not finish in time, canceling them", "timeout", s.shutdownTimeout)
+			cancel()
+		}
+		<-done
+	}()
+
+	// Exports are incremental, overlapping runs would export the same time range again.
+	running := make(chan struct{}, 1)
+	ticker := time.NewTicker(time.Duration(s.conf.Interval))
+	defer ticker.Stop()
+	for {
+		// Spread the exports of jobs with the same interval, so they do not hit the input at the same time.
+		var jitter time.Duration
+		if s.conf.Jitter > 0 {
+			jitter = time.Duration(rand.Int63n(int64(s.conf.Jitter)))
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(jitter):
+		}
+
+		select {
+		case running <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-running }()
+
+				s.export(ctx)
+			}()
+		default:
+			level.Warn(s.logger).Log("msg", "skipping scheduled export, previous export is still running")
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *scheduledJob) export(ctx context.Context) {
+	start := time.Now()
+	level.Debug(s.logger).Log("msg", "starting scheduled export")
+	p := s.conf.exportParams()
+	p.incremental = true
+	p.metrics = s.metrics
+	if err := export(ctx, s.logger, s.conf.Input, s.conf.Output, p); err != nil {
+		level.Error(s.logger).Log("msg", "scheduled export failed", "err", err, "duration", time.Since(start))
+		return
+	}
+	level.Debug(s.logger).Log("msg", "scheduled export finished", "duration", time.Since(start))
+}
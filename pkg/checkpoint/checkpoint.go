@@ -0,0 +1,66 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/thanos-io/objstore"
+)
+
+// Checkpoint records the progress of incremental exports of a single job.
+type Checkpoint struct {
+	Job string `json:"job"`
+	// LastExportStart is the start of the time range of the last export, zero for checkpoints saved by older versions.
+	LastExportStart time.Time `json:"last_export_start"`
+	// LastSampleEnd is the end of the last fully exported window. Next export continues from it.
+	LastSampleEnd time.Time `json:"last_sample_end"`
+}
+
+// Path returns the path of the checkpoint object of the job, stored next to the job output.
+func Path(outputPath, job string) string {
+	return path.Join(path.Dir(outputPath), job+".checkpoint.json")
+}
+
+// Load reads the checkpoint from the bucket. Returns nil checkpoint if it does not exist yet.
+func Load(ctx context.Context, bkt objstore.BucketReader, name string) (*Checkpoint, error) {
+	r, err := bkt.Get(ctx, name)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get checkpoint %s", name)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read checkpoint %s", name)
+	}
+
+	c := &Checkpoint{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal checkpoint %s", name)
+	}
+	return c, nil
+}
+
+// Save uploads the checkpoint into the bucket, replacing the previous one.
+// NOTE: The checkpoint is replaced unconditionally, even if it was changed since it was loaded.
+func Save(ctx context.Context, bkt objstore.Bucket, name string, c Checkpoint) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal checkpoint")
+	}
+	if err := bkt.Upload(ctx, name, bytes.NewReader(b)); err != nil {
+		return errors.Wrapf(err, "upload checkpoint %s", name)
+	}
+	return nil
+}
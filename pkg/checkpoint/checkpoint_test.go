@@ -0,0 +1,31 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package checkpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/objstore"
+)
+
+func TestCheckpoint_SaveLoad(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	name := Path("exports/cpu.parquet", "cpu")
+	testutil.Equals(t, "exports/cpu.checkpoint.json", name)
+
+	c, err := Load(ctx, bkt, name)
+	testutil.Ok(t, err)
+	testutil.Assert(t, c == nil, "expected no checkpoint")
+
+	end := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	testutil.Ok(t, Save(ctx, bkt, name, Checkpoint{Job: "cpu", LastSampleEnd: end}))
+
+	c, err = Load(ctx, bkt, name)
+	testutil.Ok(t, err)
+	testutil.Equals(t, &Checkpoint{Job: "cpu", LastSampleEnd: end}, c)
+}
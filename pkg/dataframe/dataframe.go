@@ -7,8 +7,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"reflect"
 	"text/tabwriter"
 	"time"
+
+	"github.com/efficientgo/core/errors"
 )
 
 type Type string
@@ -23,11 +26,26 @@ const (
 type Column struct {
 	Name string
 	Type Type
+	// Required columns never contain null values.
+	Required bool
 }
 
 // Schema defines columns to be exposed by the dataframe.
 type Schema []Column
 
+// Dataframe exposes ingested data to be used to turn into tabular format.
+type Dataframe interface {
+	Schema() Schema
+	// Batches returns the iterator over the rows of the dataframe in batches, stored column by column.
+	Batches() BatchIterator
+}
+
+// BatchIterator exposes the batches of the dataframe.
+type BatchIterator interface {
+	Next() bool
+	At() Batch
+}
+
 // RowsIterator exposes the rows of the dataframe.
 type RowsIterator interface {
 	Next() bool
@@ -37,12 +55,158 @@ type RowsIterator interface {
 // Row stores a single line of a table - the order of columns is defined by the Schema.
 type Row []interface{}
 
-// Dataframe exposes ingested data to be used to turn into tabular format.
-type Dataframe interface {
-	Schema() Schema
-	RowsIterator() RowsIterator
+// FromBatches returns in-memory dataframe with the given batches. Columns of the batches have to be of the schema
+// types, see ColumnVector.
+func FromBatches(schema Schema, batches ...Batch) Dataframe {
+	return &batchesDataframe{schema: schema, batches: batches}
+}
+
+// FromRows returns in-memory dataframe with the given rows, stored in a single batch. Values not matching the type of
+// their column are null, use FromRowsE to reject them. Panics on unknown column types.
+func FromRows(schema Schema, rows []Row) Dataframe {
+	df, err := fromRows(schema, rows, true)
+	if err != nil {
+		panic(err)
+	}
+	return df
+}
+
+// FromRowsE is like FromRows, but returns an error if a value does not match the type of its column.
+func FromRowsE(schema Schema, rows []Row) (Dataframe, error) {
+	return fromRows(schema, rows, false)
+}
+
+func fromRows(schema Schema, rows []Row, lenient bool) (Dataframe, error) {
+	b, err := newBatch(schema)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return FromBatches(schema), nil
+	}
+	for _, r := range rows {
+		for c, v := range b.Columns {
+			var cell interface{}
+			if c < len(r) {
+				cell = r[c]
+			}
+			if err := v.appendValue(cell); err != nil {
+				if !lenient {
+					return nil, errors.Wrapf(err, "column %s", schema[c].Name)
+				}
+				// Appending null never fails.
+				_ = v.appendValue(nil)
+			}
+		}
+	}
+	return FromBatches(schema, b), nil
+}
+
+// batchesDataframe implements Dataframe.
+type batchesDataframe struct {
+	schema  Schema
+	batches []Batch
+}
+
+func (df *batchesDataframe) Schema() Schema { return df.schema }
+
+func (df *batchesDataframe) Batches() BatchIterator {
+	return &batchesIterator{batches: df.batches, i: -1}
+}
+
+type batchesIterator struct {
+	batches []Batch
+	i       int
+}
+
+func (i *batchesIterator) Next() bool {
+	if i.i+1 >= len(i.batches) {
+		return false
+	}
+	i.i++
+	return true
+}
+
+func (i *batchesIterator) At() Batch { return i.batches[i.i] }
+
+// Rows returns the iterator over single rows of the dataframe, for consumers not able to read the batches.
+// Every value is boxed.
+func Rows(df Dataframe) RowsIterator {
+	return &rowsIterator{batches: df.Batches(), i: -1}
+}
+
+type rowsIterator struct {
+	batches BatchIterator
+	cur     Batch
+	i       int
+}
+
+func (i *rowsIterator) Next() bool {
+	for i.i+1 >= i.cur.Len() {
+		if !i.batches.Next() {
+			return false
+		}
+		i.cur, i.i = i.batches.At(), -1
+	}
+	i.i++
+	return true
+}
+
+func (i *rowsIterator) At() Row {
+	r := make(Row, 0, len(i.cur.Columns))
+	for _, c := range i.cur.Columns {
+		r = append(r, c.Value(i.i))
+	}
+	return r
+}
+
+// Concat returns dataframe with rows of all given dataframes, one after another. All dataframes have to have
+// the same schema.
+func Concat(dfs ...Dataframe) (Dataframe, error) {
+	if len(dfs) == 0 {
+		return FromRows(nil, nil), nil
+	}
+	schema := dfs[0].Schema()
+	for _, df := range dfs[1:] {
+		if !reflect.DeepEqual(schema, df.Schema()) {
+			return nil, errors.Newf("can't concatenate dataframes with different schemas %v and %v", schema, df.Schema())
+		}
+	}
+	return &concatDataframe{schema: schema, dfs: dfs}, nil
+}
+
+// concatDataframe implements Dataframe.
+type concatDataframe struct {
+	schema Schema
+	dfs    []Dataframe
+}
+
+func (df *concatDataframe) Schema() Schema { return df.schema }
+
+func (df *concatDataframe) Batches() BatchIterator {
+	return &concatIterator{dfs: df.dfs}
+}
+
+type concatIterator struct {
+	dfs []Dataframe
+	cur BatchIterator
+}
+
+func (i *concatIterator) Next() bool {
+	for {
+		if i.cur != nil && i.cur.Next() {
+			return true
+		}
+		if len(i.dfs) == 0 {
+			return false
+		}
+		i.cur = i.dfs[0].Batches()
+		i.dfs = i.dfs[1:]
+	}
 }
 
+func (i *concatIterator) At() Batch { return i.cur.At() }
+
 // Print formats the dataframe into format usable for debugging and testing purposes (e.g. in
 // examples). Uses tabwriter to produce the table in readable format and shortens
 // fields when possible (such as using only time part of a timestamp) so it fits
@@ -51,7 +215,7 @@ func Print(w io.Writer, df Dataframe) {
 	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
 
 	printHeader(tw, df)
-	i := df.RowsIterator()
+	i := Rows(df)
 	for i.Next() {
 		printRow(tw, df.Schema(), i.At())
 	}
@@ -77,6 +241,10 @@ func printHeader(w io.Writer, df Dataframe) {
 func printRow(w io.Writer, s Schema, r Row) {
 	fmt.Fprint(w, "| ")
 	for i, cell := range r {
+		if cell == nil {
+			fmt.Fprint(w, "null\t")
+			continue
+		}
 		c := s[i]
 		switch c.Type {
 		case TypeString:
@@ -0,0 +1,231 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+)
+
+// JoinInput is a dataframe to join.
+type JoinInput struct {
+	// Name prefixes the aggregation columns of the dataframe, e.g. _sum column of cpu input is joined as cpu_sum.
+	Name      string
+	Dataframe Dataframe
+}
+
+// windowColumns identify the window of the row, joined together with the on columns.
+var windowColumns = []string{"_resolution", "_sample_start", "_sample_end"}
+
+// Join returns a wide dataframe joining rows of the inputs with the same values of the on columns (e.g. labels)
+// and of the same window, the same way as SQL full outer join does. All inputs are expected to be aggregated
+// in the same resolution, e.g. with AggrsOptions.By set to the on columns.
+//
+// Aggregation columns (starting with _) of every input are prefixed with the input name. Other columns (labels) keep
+// their name, unless multiple inputs have them, in which case they are prefixed too. Columns of inputs without
+// a matching row are null. Inputs with multiple matching rows produce a row for every combination of them.
+func Join(on []string, inputs ...JoinInput) (Dataframe, error) {
+	if len(inputs) == 0 {
+		return FromRows(nil, nil), nil
+	}
+
+	keyColumns := map[string]struct{}{}
+	for _, c := range on {
+		keyColumns[c] = struct{}{}
+	}
+	for _, c := range windowColumns {
+		keyColumns[c] = struct{}{}
+	}
+
+	// Labels present in multiple inputs are prefixed, as their values might differ.
+	labelInputs := map[string]int{}
+	names := map[string]struct{}{}
+	for _, in := range inputs {
+		if _, ok := names[in.Name]; ok || in.Name == "" {
+			return nil, errors.Newf("join inputs need unique, non-empty names, got %q", in.Name)
+		}
+		names[in.Name] = struct{}{}
+		for _, c := range in.Dataframe.Schema() {
+			if _, ok := keyColumns[c.Name]; !ok && !isAggregationColumn(c.Name) {
+				labelInputs[c.Name]++
+			}
+		}
+	}
+
+	var (
+		schema = Schema{}
+		// Columns of every input in the joined schema, by the position in the input schema. -1 for key columns.
+		inputColumns = make([][]int, len(inputs))
+		// Key columns of the joined schema, by the key column name.
+		keyIndex = map[string]int{}
+		seen     = map[string]bool{}
+	)
+	addColumn := func(c Column) (int, error) {
+		if seen[c.Name] {
+			return 0, errors.Newf("joined column %q is not unique", c.Name)
+		}
+		seen[c.Name] = true
+		schema = append(schema, c)
+		return len(schema) - 1, nil
+	}
+
+	for _, c := range on {
+		i, err := addColumn(Column{Name: c, Type: TypeString})
+		if err != nil {
+			return nil, err
+		}
+		keyIndex[c] = i
+	}
+	// Labels first, then the window columns and the aggregations, the same way as FromSeries orders them.
+	for n, in := range inputs {
+		inputColumns[n] = make([]int, len(in.Dataframe.Schema()))
+		for i, c := range in.Dataframe.Schema() {
+			inputColumns[n][i] = -1
+			if _, ok := keyColumns[c.Name]; ok || isAggregationColumn(c.Name) {
+				continue
+			}
+			if labelInputs[c.Name] > 1 {
+				c.Name = in.Name + "_" + c.Name
+			}
+			idx, err := addColumn(c)
+			if err != nil {
+				return nil, err
+			}
+			inputColumns[n][i] = idx
+		}
+	}
+	for _, c := range windowColumns {
+		for _, in := range inputs {
+			if i := columnIndex(in.Dataframe.Schema(), c); i >= 0 && !seen[c] {
+				idx, err := addColumn(in.Dataframe.Schema()[i])
+				if err != nil {
+					return nil, err
+				}
+				keyIndex[c] = idx
+			}
+		}
+	}
+	for n, in := range inputs {
+		for i, c := range in.Dataframe.Schema() {
+			if _, ok := keyColumns[c.Name]; ok || !isAggregationColumn(c.Name) {
+				continue
+			}
+			c.Name = in.Name + "_" + strings.TrimPrefix(c.Name, "_")
+			// Inputs without a row for the key and window have null aggregations.
+			c.Required = false
+			idx, err := addColumn(c)
+			if err != nil {
+				return nil, err
+			}
+			inputColumns[n][i] = idx
+		}
+	}
+
+	// Group rows of every input by the key, keeping the order of keys as they were seen first.
+	var (
+		keys       []string
+		keyValues  = map[string]map[string]interface{}{}
+		keyedRows  = map[string][][]Row{}
+		keyBuilder strings.Builder
+	)
+	for n, in := range inputs {
+		s := in.Dataframe.Schema()
+		for it := Rows(in.Dataframe); it.Next(); {
+			row := it.At()
+			vals := map[string]interface{}{}
+			for i, c := range s {
+				if inputColumns[n][i] == -1 {
+					vals[c.Name] = row[i]
+				}
+			}
+
+			keyBuilder.Reset()
+			for _, c := range schema {
+				if _, ok := keyIndex[c.Name]; ok {
+					keyBuilder.WriteString(keyValue(vals[c.Name]))
+					keyBuilder.WriteByte(0xff)
+				}
+			}
+			key := keyBuilder.String()
+			if _, ok := keyedRows[key]; !ok {
+				keys = append(keys, key)
+				keyValues[key] = vals
+				keyedRows[key] = make([][]Row, len(inputs))
+			}
+			keyedRows[key][n] = append(keyedRows[key][n], row)
+		}
+	}
+
+	var rows []Row
+	for _, key := range keys {
+		base := make(Row, len(schema))
+		for c, i := range keyIndex {
+			base[i] = keyValues[key][c]
+		}
+		combined := []Row{base}
+		for n, inRows := range keyedRows[key] {
+			if len(inRows) == 0 {
+				// Columns of the input are null.
+				continue
+			}
+			next := make([]Row, 0, len(combined)*len(inRows))
+			for _, r := range combined {
+				for _, inRow := range inRows {
+					nr := make(Row, len(r))
+					copy(nr, r)
+					for i, idx := range inputColumns[n] {
+						if idx >= 0 {
+							nr[idx] = inRow[i]
+						}
+					}
+					next = append(next, nr)
+				}
+			}
+			combined = next
+		}
+		rows = append(rows, combined...)
+	}
+	return FromRows(schema, rows), nil
+}
+
+// keyValue encodes the value of the key column, distinguishing null from empty string.
+func keyValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "n"
+	case time.Time:
+		return fmt.Sprintf("t%d", v.UnixNano())
+	default:
+		return fmt.Sprintf("v%v", v)
+	}
+}
+
+// isAggregationColumn returns true if the column holds an aggregation of the window, e.g. _sum. Aggregation columns
+// start with _, unlike labels, except for the reserved ones (e.g. __name__).
+func isAggregationColumn(name string) bool {
+	return strings.HasPrefix(name, "_") && !strings.HasPrefix(name, "__") && !isWindowColumn(name)
+}
+
+// isWindowColumn returns true if the column identifies the window of the row.
+func isWindowColumn(name string) bool {
+	for _, c := range windowColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnIndex returns the position of the column in the schema, -1 if missing.
+func columnIndex(s Schema, name string) int {
+	for i, c := range s {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
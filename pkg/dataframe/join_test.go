@@ -0,0 +1,66 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func TestJoin(t *testing.T) {
+	cpu, err := FromSeries(series.NewListSet(
+		newTestSeries(labels.FromStrings("__name__", "cpu", "namespace", "ns", "pod", "a", "container", "c1"), sample{t: 10000, v: 1}, sample{t: 70000, v: 2}),
+		newTestSeries(labels.FromStrings("__name__", "cpu", "namespace", "ns", "pod", "a", "container", "c2"), sample{t: 20000, v: 3}),
+		newTestSeries(labels.FromStrings("__name__", "cpu", "namespace", "ns", "pod", "b", "container", "c1"), sample{t: 10000, v: 5}),
+	), FixedResolution(time.Minute), func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+		o.By = []string{"namespace", "pod", "label_team"}
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `| namespace  pod  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  |
+| ns         a    00:00:00       00:01:00     00:00:10   00:00:20   2       4     |
+| ns         a    00:01:00       00:02:00     00:01:10   00:01:10   1       2     |
+| ns         b    00:00:00       00:01:00     00:00:10   00:00:10   1       5     |
+`, ToString(cpu))
+
+	owners, err := FromSeries(series.NewListSet(
+		newTestSeries(labels.FromStrings("__name__", "kube_pod_labels", "namespace", "ns", "pod", "a", "label_team", "x", "uid", "1"), sample{t: 30000, v: 1}),
+		newTestSeries(labels.FromStrings("__name__", "kube_pod_labels", "namespace", "ns", "pod", "c", "label_team", "y", "uid", "2"), sample{t: 30000, v: 1}),
+	), FixedResolution(time.Minute), func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.By = []string{"namespace", "pod", "label_team"}
+	})
+	testutil.Ok(t, err)
+
+	df, err := Join([]string{"namespace", "pod"}, JoinInput{Name: "cpu", Dataframe: cpu}, JoinInput{Name: "owner", Dataframe: owners})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `| namespace  pod  label_team  _sample_start  _sample_end  cpu_min_time  cpu_max_time  cpu_count  cpu_sum  owner_min_time  owner_max_time  owner_count  |
+| ns         a    x           00:00:00       00:01:00     00:00:10      00:00:20      2          4        00:00:30        00:00:30        1            |
+| ns         a    null        00:01:00       00:02:00     00:01:10      00:01:10      1          2        null            null            null         |
+| ns         b    null        00:00:00       00:01:00     00:00:10      00:00:10      1          5        null            null            null         |
+| ns         c    y           00:00:00       00:01:00     null          null          null       null     00:00:30        00:00:30        1            |
+`, ToString(df))
+
+	t.Run("labels of multiple inputs are prefixed", func(t *testing.T) {
+		df, err := Join([]string{"namespace"}, JoinInput{Name: "cpu", Dataframe: cpu}, JoinInput{Name: "owner", Dataframe: owners})
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| namespace  cpu_pod  label_team  owner_pod  _sample_start  _sample_end  cpu_min_time  cpu_max_time  cpu_count  cpu_sum  owner_min_time  owner_max_time  owner_count  |
+| ns         a        x           a          00:00:00       00:01:00     00:00:10      00:00:20      2          4        00:00:30        00:00:30        1            |
+| ns         a        y           c          00:00:00       00:01:00     00:00:10      00:00:20      2          4        00:00:30        00:00:30        1            |
+| ns         b        x           a          00:00:00       00:01:00     00:00:10      00:00:10      1          5        00:00:30        00:00:30        1            |
+| ns         b        y           c          00:00:00       00:01:00     00:00:10      00:00:10      1          5        00:00:30        00:00:30        1            |
+| ns         a        null        null       00:01:00       00:02:00     00:01:10      00:01:10      1          2        null            null            null         |
+`, ToString(df))
+	})
+	t.Run("names have to be unique", func(t *testing.T) {
+		_, err := Join([]string{"pod"}, JoinInput{Name: "cpu", Dataframe: cpu}, JoinInput{Name: "cpu", Dataframe: owners})
+		testutil.NotOk(t, err)
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// PivotOptions configures the names of the pivoted columns.
+type PivotOptions struct {
+	// LabelPrefix prefixes the aggregation columns with the pivoted label too, e.g. mode_idle_sum instead of idle_sum.
+	LabelPrefix bool
+}
+
+// Pivot returns a wide dataframe with a row for every window and values of the labels other than the given label,
+// e.g. mode of node_cpu_seconds_total, or __name__ to pivot multiple metrics. Aggregation columns (e.g. _sum) of rows
+// with every value of the label are prefixed with the value, e.g. _sum of the rows with idle mode is pivoted into
+// idle_sum (or mode_idle_sum with PivotOptions.LabelPrefix). Characters not valid in SQL identifiers are replaced by _,
+// e.g. 0_99_sum, and values pivoted into the same columns are rejected. Metric names are never prefixed with __name__.
+// Values are sorted. Columns of values without a matching row are null.
+// NOTE: The schema depends on the values present in the dataframe, so dataframes with different values have different
+// columns.
+func Pivot(df Dataframe, column string, opts ...func(o *PivotOptions)) (Dataframe, error) {
+	var o PivotOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := df.Schema()
+	idx := columnIndex(s, column)
+	if idx < 0 {
+		return nil, errors.Newf("pivoted column %q not found", column)
+	}
+	if s[idx].Type != TypeString {
+		return nil, errors.Newf("pivoted column %q is not a string column", column)
+	}
+	if isAggregationColumn(column) || isWindowColumn(column) {
+		return nil, errors.Newf("column %q can't be pivoted, only labels can", column)
+	}
+
+	var (
+		// Rows of every value, without the pivoted column.
+		valueRows = map[string][]Row{}
+		values    []string
+		schema    = make(Schema, 0, len(s)-1)
+		on        []string
+	)
+	for i, c := range s {
+		if i == idx {
+			continue
+		}
+		schema = append(schema, c)
+		if !isAggregationColumn(c.Name) && !isWindowColumn(c.Name) {
+			on = append(on, c.Name)
+		}
+	}
+	for it := Rows(df); it.Next(); {
+		row := it.At()
+		v, _ := row[idx].(string)
+		if v == "" {
+			return nil, errors.Newf("rows without %q value can't be pivoted", column)
+		}
+		if _, ok := valueRows[v]; !ok {
+			values = append(values, v)
+		}
+		r := make(Row, 0, len(row)-1)
+		r = append(append(r, row[:idx]...), row[idx+1:]...)
+		valueRows[v] = append(valueRows[v], r)
+	}
+	sort.Strings(values)
+
+	if len(values) == 0 {
+		// There are no values to prefix the aggregation columns with, keep the labels and the window columns only.
+		keys := Schema{}
+		for _, c := range schema {
+			if !isAggregationColumn(c.Name) {
+				keys = append(keys, c)
+			}
+		}
+		return FromRows(keys, nil), nil
+	}
+	var (
+		inputs   = make([]JoinInput, 0, len(values))
+		prefixes = map[string]string{}
+	)
+	for _, v := range values {
+		prefix := pivotPrefix(column, v, o.LabelPrefix)
+		if other, ok := prefixes[prefix]; ok {
+			return nil, errors.Newf("values %q and %q of column %q are both pivoted into %s_ columns", other, v, column, prefix)
+		}
+		prefixes[prefix] = v
+		inputs = append(inputs, JoinInput{Name: prefix, Dataframe: FromRows(schema, valueRows[v])})
+	}
+	return Join(on, inputs...)
+}
+
+var invalidColumnCharsRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// pivotPrefix returns the prefix of the aggregation columns of the value of the pivoted column, e.g. idle, or mode_idle
+// prefixed with the label. Characters not valid in SQL identifiers are replaced by _, e.g. the 0.99 quantile is
+// prefixed with 0_99. Metric names (__name__) are never prefixed with the label, e.g. node_load1.
+func pivotPrefix(column, value string, labelPrefix bool) string {
+	value = invalidColumnCharsRe.ReplaceAllString(value, "_")
+	if !labelPrefix || column == labels.MetricName {
+		return value
+	}
+	return column + "_" + value
+}
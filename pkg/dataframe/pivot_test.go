@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func TestPivot(t *testing.T) {
+	df, err := FromSeries(series.NewListSet(
+		newTestSeries(labels.FromStrings("__name__", "node_cpu_seconds_total", "cpu", "0", "mode", "user"), sample{t: 10000, v: 1}, sample{t: 70000, v: 2}),
+		newTestSeries(labels.FromStrings("__name__", "node_cpu_seconds_total", "cpu", "0", "mode", "idle"), sample{t: 10000, v: 3}),
+		newTestSeries(labels.FromStrings("__name__", "node_cpu_seconds_total", "cpu", "1", "mode", "idle"), sample{t: 20000, v: 5}),
+	), FixedResolution(time.Minute), func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	pivoted, err := Pivot(df, "mode")
+	testutil.Ok(t, err)
+	testutil.Equals(t, `| cpu  _sample_start  _sample_end  idle_min_time  idle_max_time  idle_count  idle_sum  user_min_time  user_max_time  user_count  user_sum  |
+| 0    00:00:00       00:01:00     00:00:10       00:00:10       1           3         00:00:10       00:00:10       1           1         |
+| 1    00:00:00       00:01:00     00:00:20       00:00:20       1           5         null           null           null        null      |
+| 0    00:01:00       00:02:00     null           null           null        null      00:01:10       00:01:10       1           2         |
+`, ToString(pivoted))
+
+	// Columns can be prefixed with the label too.
+	pivoted, err = Pivot(df, "mode", func(o *PivotOptions) { o.LabelPrefix = true })
+	testutil.Ok(t, err)
+	testutil.Equals(t, "mode_idle_sum", pivoted.Schema()[6].Name)
+
+	// Only the labels and window columns are kept without rows.
+	empty, err := Pivot(FromRows(df.Schema(), nil), "mode")
+	testutil.Ok(t, err)
+	testutil.Equals(t, Schema{
+		{Name: "cpu", Type: TypeString},
+		{Name: "_sample_start", Type: TypeTime, Required: true},
+		{Name: "_sample_end", Type: TypeTime, Required: true},
+	}, empty.Schema())
+
+	t.Run("metrics", func(t *testing.T) {
+		df, err := FromSeries(series.NewListSet(
+			newTestSeries(labels.FromStrings("__name__", "node_load1", "instance", "a"), sample{t: 10000, v: 1}),
+			newTestSeries(labels.FromStrings("__name__", "node_load5", "instance", "a"), sample{t: 10000, v: 2}),
+		), FixedResolution(time.Minute), func(o *AggrsOptions) {
+			o.MetricName.Enabled = true
+			o.Max.Enabled = true
+		})
+		testutil.Ok(t, err)
+
+		pivoted, err := Pivot(df, "__name__")
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  node_load1_min_time  node_load1_max_time  node_load1_max  node_load5_min_time  node_load5_max_time  node_load5_max  |
+| a         00:00:00       00:01:00     00:00:10             00:00:10             1               00:00:10             00:00:10             2               |
+`, ToString(pivoted))
+	})
+
+	t.Run("values not valid in identifiers", func(t *testing.T) {
+		newSet := func(quantiles ...string) series.Set {
+			var ss []storage.Series
+			for i, q := range quantiles {
+				ss = append(ss, newTestSeries(labels.FromStrings("__name__", "rpc_duration_seconds", "quantile", q), sample{t: 10000, v: float64(i)}))
+			}
+			return series.NewListSet(ss...)
+		}
+		df, err := FromSeries(newSet("0.5", "0.99"), FixedResolution(time.Minute), func(o *AggrsOptions) { o.Max.Enabled = true })
+		testutil.Ok(t, err)
+
+		pivoted, err := Pivot(df, "quantile")
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| _sample_start  _sample_end  0_5_min_time  0_5_max_time  0_5_max  0_99_min_time  0_99_max_time  0_99_max  |
+| 00:00:00       00:01:00     00:00:10      00:00:10      0        00:00:10       00:00:10       1         |
+`, ToString(pivoted))
+
+		// Values can't be pivoted into the same columns.
+		df, err = FromSeries(newSet("0.5", "0_5"), FixedResolution(time.Minute), func(o *AggrsOptions) { o.Max.Enabled = true })
+		testutil.Ok(t, err)
+		_, err = Pivot(df, "quantile")
+		testutil.NotOk(t, err)
+	})
+
+	for _, column := range []string{"instance", "_sum", "_count", "_sample_start"} {
+		_, err := Pivot(df, column)
+		testutil.NotOk(t, err, column)
+	}
+}
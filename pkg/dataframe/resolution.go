@@ -0,0 +1,229 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+// Resolution determines the consecutive, non-overlapping windows the samples are aggregated into. Windows are
+// half-open, [start, end), so every sample belongs to exactly one window.
+type Resolution interface {
+	// WindowStart returns the start of the window containing t.
+	WindowStart(t time.Time) time.Time
+	// WindowEnd returns the end of the window starting at start, which is the start of the following window.
+	WindowEnd(start time.Time) time.Time
+	String() string
+}
+
+// FixedResolution is a resolution of windows of the fixed duration, aligned to the Unix epoch. It has to be
+// a positive number of milliseconds, the precision of the sample timestamps.
+type FixedResolution time.Duration
+
+func (r FixedResolution) WindowStart(t time.Time) time.Time {
+	ms := timestamp.FromTime(t)
+	return timestamp.Time(ms - floorMod(ms, time.Duration(r).Milliseconds()))
+}
+
+func (r FixedResolution) WindowEnd(start time.Time) time.Time {
+	return start.Add(time.Duration(r))
+}
+
+func (r FixedResolution) String() string {
+	return model.Duration(r).String()
+}
+
+// CalendarUnit is the unit of calendar resolutions.
+type CalendarUnit string
+
+const (
+	// Day windows start at midnight.
+	Day CalendarUnit = "d"
+	// Week windows start at Monday midnight.
+	Week CalendarUnit = "w"
+	// Month windows start at midnight of the first day of the month.
+	Month CalendarUnit = "mo"
+)
+
+// CalendarResolution is a resolution of windows of the given number of calendar days, weeks or months in the location.
+// Windows follow the local time, e.g. days are 23 or 25 hours long when the daylight saving time starts or ends.
+// Windows of multiple units are aligned to 1970-01-01 (days), 1970-01-05 (weeks, as it is Monday) or to the start
+// of the year (months), e.g. 3mo windows are quarters.
+type CalendarResolution struct {
+	Unit  CalendarUnit
+	Count int
+	// Location the windows are aligned in. UTC if nil.
+	Location *time.Location
+	// Offset of the window start from the local midnight, e.g. 6h for days starting at 06:00.
+	Offset time.Duration
+}
+
+// epochMonday is the number of days between the Unix epoch (Thursday) and the first Monday.
+const epochMonday = 4
+
+func (r CalendarResolution) WindowStart(t time.Time) time.Time {
+	y, m, d := r.localDate(t)
+	switch r.Unit {
+	case Week:
+		days := daysSinceEpoch(y, m, d) - epochMonday
+		d -= floorMod(days, 7*r.count())
+	case Month:
+		m -= time.Month(floorMod((y-1970)*12+int(m)-1, r.count()))
+		d = 1
+	default:
+		d -= floorMod(daysSinceEpoch(y, m, d), r.count())
+	}
+
+	// The local time of the window start is skipped or repeated when the daylight saving time starts or ends,
+	// so it might resolve to an absolute time after t, or the end of the window before t. The window containing
+	// t is then the previous or the next one.
+	start := r.date(y, m, d)
+	if start.After(t) {
+		return r.date(r.addWindows(y, m, d, -1))
+	}
+	if end := r.date(r.addWindows(y, m, d, 1)); !t.Before(end) {
+		return end
+	}
+	return start
+}
+
+func (r CalendarResolution) WindowEnd(start time.Time) time.Time {
+	y, m, d := r.localDate(start)
+	return r.date(r.addWindows(y, m, d, 1))
+}
+
+func (r CalendarResolution) String() string {
+	if r.Offset != 0 {
+		return fmt.Sprintf("%d%s+%s", r.count(), r.Unit, model.Duration(r.Offset))
+	}
+	return fmt.Sprintf("%d%s", r.count(), r.Unit)
+}
+
+// localDate returns the date of t in the location, shifted back by the offset.
+func (r CalendarResolution) localDate(t time.Time) (int, time.Month, int) {
+	lt := t.In(r.location())
+	// Shift the wall clock, not the absolute time, so the offset is the same on days the daylight saving time changes.
+	wall := time.Date(lt.Year(), lt.Month(), lt.Day(), lt.Hour(), lt.Minute(), lt.Second(), lt.Nanosecond(), time.UTC)
+	return wall.Add(-r.Offset).Date()
+}
+
+// date returns the start of the window of the given local date.
+func (r CalendarResolution) date(y int, m time.Month, d int) time.Time {
+	// The offset is normalized as nanoseconds of the local wall clock.
+	return time.Date(y, m, d, 0, 0, 0, int(r.Offset), r.location())
+}
+
+// addWindows moves the date by n windows.
+func (r CalendarResolution) addWindows(y int, m time.Month, d, n int) (int, time.Month, int) {
+	switch r.Unit {
+	case Week:
+		d += 7 * r.count() * n
+	case Month:
+		m += time.Month(r.count() * n)
+	default:
+		d += r.count() * n
+	}
+	return y, m, d
+}
+
+func (r CalendarResolution) location() *time.Location {
+	if r.Location == nil {
+		return time.UTC
+	}
+	return r.Location
+}
+
+func (r CalendarResolution) count() int {
+	if r.Count <= 0 {
+		return 1
+	}
+	return r.Count
+}
+
+// WithOffset returns the resolution with the windows shifted by the offset, e.g. 1h windows starting at :15
+// or 1d windows starting at 06:00. Offset of calendar resolutions is applied to the local time.
+func WithOffset(r Resolution, offset time.Duration) Resolution {
+	switch res := r.(type) {
+	case CalendarResolution:
+		res.Offset = offset
+		return res
+	case FixedResolution:
+		// Offsets of whole windows do not change the windows.
+		offset = time.Duration(floorMod(int64(offset), int64(res)))
+	}
+	if offset == 0 {
+		return r
+	}
+	return offsetResolution{Resolution: r, offset: offset}
+}
+
+// offsetResolution shifts the windows of the resolution by the offset.
+type offsetResolution struct {
+	Resolution
+	offset time.Duration
+}
+
+func (r offsetResolution) WindowStart(t time.Time) time.Time {
+	return r.Resolution.WindowStart(t.Add(-r.offset)).Add(r.offset)
+}
+
+func (r offsetResolution) WindowEnd(start time.Time) time.Time {
+	return r.Resolution.WindowEnd(start.Add(-r.offset)).Add(r.offset)
+}
+
+func (r offsetResolution) String() string {
+	return fmt.Sprintf("%s+%s", r.Resolution, model.Duration(r.offset))
+}
+
+// validateResolution checks the resolution produces non-empty windows.
+func validateResolution(r Resolution) error {
+	switch res := r.(type) {
+	case FixedResolution:
+		if res < FixedResolution(time.Millisecond) || time.Duration(res)%time.Millisecond != 0 {
+			return errors.Newf("invalid resolution %s, expected positive number of milliseconds", time.Duration(res))
+		}
+	case offsetResolution:
+		return validateResolution(res.Resolution)
+	}
+	return nil
+}
+
+var calendarResolutionRe = regexp.MustCompile(`^([0-9]+)(d|w|mo)$`)
+
+// ParseResolution parses the resolution. Days (e.g. 1d), weeks (1w) and months (1mo) are calendar resolutions
+// aligned in the given location, other durations (e.g. 30m) are fixed resolutions.
+func ParseResolution(s string, loc *time.Location) (Resolution, error) {
+	if m := calendarResolutionRe.FindStringSubmatch(s); m != nil {
+		count, err := strconv.Atoi(m[1])
+		if err != nil || count <= 0 {
+			return nil, errors.Newf("invalid resolution %q, expected positive number of units", s)
+		}
+		return CalendarResolution{Unit: CalendarUnit(m[2]), Count: count, Location: loc}, nil
+	}
+
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid resolution %q", s)
+	}
+	if d <= 0 {
+		return nil, errors.Newf("invalid resolution %q, expected positive duration", s)
+	}
+	return FixedResolution(d), nil
+}
+
+// daysSinceEpoch returns the number of days between the Unix epoch and the given date.
+func daysSinceEpoch(y int, m time.Month, d int) int {
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+}
+
+func floorMod[T int | int64](a, b T) T {
+	return ((a % b) + b) % b
+}
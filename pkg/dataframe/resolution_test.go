@@ -0,0 +1,189 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestCalendarResolution(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	testutil.Ok(t, err)
+
+	date := func(y int, m time.Month, d, h int) time.Time { return time.Date(y, m, d, h, 0, 0, 0, prague) }
+	for _, tcase := range []struct {
+		resolution string
+		t          time.Time
+
+		start, end time.Time
+	}{
+		{resolution: "1d", t: date(2022, 6, 15, 13), start: date(2022, 6, 15, 0), end: date(2022, 6, 16, 0)},
+		// Local midnight is 22:00 UTC of the previous day in summer.
+		{resolution: "1d", t: time.Date(2022, 6, 14, 22, 30, 0, 0, time.UTC), start: date(2022, 6, 15, 0), end: date(2022, 6, 16, 0)},
+		// Daylight saving time starts, the day has 23 hours.
+		{resolution: "1d", t: date(2022, 3, 27, 12), start: date(2022, 3, 27, 0), end: date(2022, 3, 28, 0)},
+		// Daylight saving time ends, the day has 25 hours.
+		{resolution: "1d", t: date(2022, 10, 30, 23), start: date(2022, 10, 30, 0), end: date(2022, 10, 31, 0)},
+		{resolution: "2d", t: date(1970, 1, 2, 12), start: date(1970, 1, 1, 0), end: date(1970, 1, 3, 0)},
+		// 2022-06-15 is Wednesday.
+		{resolution: "1w", t: date(2022, 6, 15, 13), start: date(2022, 6, 13, 0), end: date(2022, 6, 20, 0)},
+		{resolution: "1w", t: date(2022, 6, 13, 0), start: date(2022, 6, 13, 0), end: date(2022, 6, 20, 0)},
+		{resolution: "1w", t: date(2022, 6, 12, 23), start: date(2022, 6, 6, 0), end: date(2022, 6, 13, 0)},
+		{resolution: "1w", t: date(1969, 12, 31, 0), start: date(1969, 12, 29, 0), end: date(1970, 1, 5, 0)},
+		{resolution: "1mo", t: date(2022, 3, 31, 23), start: date(2022, 3, 1, 0), end: date(2022, 4, 1, 0)},
+		{resolution: "1mo", t: date(2022, 12, 1, 0), start: date(2022, 12, 1, 0), end: date(2023, 1, 1, 0)},
+		{resolution: "3mo", t: date(2022, 8, 20, 10), start: date(2022, 7, 1, 0), end: date(2022, 10, 1, 0)},
+		{resolution: "3mo", t: date(1969, 12, 20, 10), start: date(1969, 10, 1, 0), end: date(1970, 1, 1, 0)},
+	} {
+		t.Run(tcase.resolution+" "+tcase.t.String(), func(t *testing.T) {
+			r, err := ParseResolution(tcase.resolution, prague)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.resolution, r.String())
+
+			start := r.WindowStart(tcase.t)
+			testutil.Assert(t, tcase.start.Equal(start), "expected start %v, got %v", tcase.start, start)
+			end := r.WindowEnd(start)
+			testutil.Assert(t, tcase.end.Equal(end), "expected end %v, got %v", tcase.end, end)
+		})
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	r, err := ParseResolution("90s", time.UTC)
+	testutil.Ok(t, err)
+	testutil.Equals(t, Resolution(FixedResolution(90*time.Second)), r)
+
+	r, err = ParseResolution("1mo", nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, Resolution(CalendarResolution{Unit: Month, Count: 1}), r)
+	testutil.Equals(t, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), r.WindowStart(time.Date(2022, 3, 31, 23, 0, 0, 0, time.UTC)))
+
+	testutil.NotOk(t, validateResolution(FixedResolution(0)))
+	testutil.NotOk(t, validateResolution(FixedResolution(500*time.Microsecond)))
+	testutil.NotOk(t, validateResolution(WithOffset(FixedResolution(1500*time.Microsecond), time.Millisecond)))
+	testutil.Ok(t, validateResolution(FixedResolution(time.Millisecond)))
+
+	for _, s := range []string{"", "0s", "0d", "1y1mo", "1 d", "mo"} {
+		_, err := ParseResolution(s, time.UTC)
+		testutil.NotOk(t, err, s)
+	}
+}
+
+func TestWithOffset(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	testutil.Ok(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	testutil.Ok(t, err)
+
+	date := func(y int, m time.Month, d, h, min int) time.Time { return time.Date(y, m, d, h, min, 0, 0, prague) }
+	for _, tcase := range []struct {
+		resolution Resolution
+		t          time.Time
+
+		name       string
+		start, end time.Time
+	}{
+		{
+			resolution: WithOffset(FixedResolution(time.Hour), 15*time.Minute), name: "1h+15m",
+			t: date(2022, 6, 15, 13, 10), start: date(2022, 6, 15, 12, 15), end: date(2022, 6, 15, 13, 15),
+		},
+		{
+			resolution: WithOffset(FixedResolution(time.Hour), 15*time.Minute), name: "1h+15m",
+			t: date(2022, 6, 15, 13, 15), start: date(2022, 6, 15, 13, 15), end: date(2022, 6, 15, 14, 15),
+		},
+		// Offsets of whole windows are normalized.
+		{
+			resolution: WithOffset(FixedResolution(time.Hour), 75*time.Minute), name: "1h+15m",
+			t: date(2022, 6, 15, 13, 10), start: date(2022, 6, 15, 12, 15), end: date(2022, 6, 15, 13, 15),
+		},
+		{resolution: WithOffset(FixedResolution(time.Hour), time.Hour), name: "1h", t: date(2022, 6, 15, 13, 10), start: date(2022, 6, 15, 13, 0), end: date(2022, 6, 15, 14, 0)},
+		{
+			resolution: WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: prague}, 6*time.Hour), name: "1d+6h",
+			t: date(2022, 6, 15, 5, 0), start: date(2022, 6, 14, 6, 0), end: date(2022, 6, 15, 6, 0),
+		},
+		// The offset follows the local time when the daylight saving time starts.
+		{
+			resolution: WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: prague}, 6*time.Hour), name: "1d+6h",
+			t: date(2022, 3, 27, 12, 0), start: date(2022, 3, 27, 6, 0), end: date(2022, 3, 28, 6, 0),
+		},
+		// 02:30 does not exist on 2022-03-27 in Prague, the window starts at 03:30 instead.
+		{
+			resolution: WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: prague}, 150*time.Minute), name: "1d+2h30m",
+			t: date(2022, 3, 27, 3, 0), start: date(2022, 3, 26, 2, 30), end: time.Date(2022, 3, 27, 1, 30, 0, 0, time.UTC),
+		},
+		// 01:30 is repeated on 2022-11-06 in New York, the window starts at the first one.
+		{
+			resolution: WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: newYork}, 90*time.Minute), name: "1d+1h30m",
+			t: time.Date(2022, 11, 6, 6, 15, 0, 0, time.UTC), start: time.Date(2022, 11, 6, 5, 30, 0, 0, time.UTC), end: time.Date(2022, 11, 7, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			resolution: WithOffset(CalendarResolution{Unit: Month, Count: 1, Location: prague}, 6*time.Hour), name: "1mo+6h",
+			t: date(2022, 7, 1, 5, 0), start: date(2022, 6, 1, 6, 0), end: date(2022, 7, 1, 6, 0),
+		},
+	} {
+		t.Run(tcase.name+" "+tcase.t.String(), func(t *testing.T) {
+			testutil.Equals(t, tcase.name, tcase.resolution.String())
+
+			start := tcase.resolution.WindowStart(tcase.t)
+			testutil.Assert(t, tcase.start.Equal(start), "expected start %v, got %v", tcase.start, start)
+			end := tcase.resolution.WindowEnd(start)
+			testutil.Assert(t, tcase.end.Equal(end), "expected end %v, got %v", tcase.end, end)
+		})
+	}
+}
+
+// TestResolution_ExactlyOneWindow checks the windows of every resolution are consecutive and every millisecond
+// belongs to exactly one of them.
+func TestResolution_ExactlyOneWindow(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	testutil.Ok(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	testutil.Ok(t, err)
+
+	for _, res := range []Resolution{
+		FixedResolution(time.Millisecond),
+		FixedResolution(7 * time.Millisecond),
+		FixedResolution(250 * time.Millisecond),
+		FixedResolution(7 * time.Minute),
+		WithOffset(FixedResolution(time.Hour), 15*time.Minute),
+		WithOffset(FixedResolution(24*time.Hour), -time.Hour),
+		CalendarResolution{Unit: Day, Count: 1, Location: prague},
+		CalendarResolution{Unit: Day, Count: 3, Location: newYork},
+		WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: prague}, 150*time.Minute),
+		WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: newYork}, 90*time.Minute),
+		CalendarResolution{Unit: Week, Count: 2, Location: prague},
+		CalendarResolution{Unit: Month, Count: 1},
+		WithOffset(CalendarResolution{Unit: Month, Count: 3, Location: prague}, 2*time.Hour),
+	} {
+		t.Run(res.String(), func(t *testing.T) {
+			f := func(ms int64) bool {
+				ts := time.UnixMilli(ms)
+				start := res.WindowStart(ts)
+				end := res.WindowEnd(start)
+				return !start.After(ts) && ts.Before(end) &&
+					// Windows start at their own start and end at the start of the following window.
+					res.WindowStart(start).Equal(start) && res.WindowStart(end).Equal(end)
+			}
+			testutil.Ok(t, quick.Check(f, &quick.Config{
+				MaxCount: 10000,
+				Values: func(v []reflect.Value, r *rand.Rand) {
+					// Between 1950 and 2090, with half of the values around the daylight saving time changes of 2022.
+					ms := r.Int63n(140*365*24*time.Hour.Milliseconds()) - 20*365*24*time.Hour.Milliseconds()
+					if r.Intn(2) == 0 {
+						dst := []time.Time{time.Date(2022, 3, 27, 1, 0, 0, 0, time.UTC), time.Date(2022, 10, 30, 1, 0, 0, 0, time.UTC),
+							time.Date(2022, 3, 13, 7, 0, 0, 0, time.UTC), time.Date(2022, 11, 6, 6, 0, 0, 0, time.UTC)}
+						ms = dst[r.Intn(len(dst))].UnixMilli() + r.Int63n(8*time.Hour.Milliseconds()) - 4*time.Hour.Milliseconds()
+					}
+					v[0] = reflect.ValueOf(ms)
+				},
+			}))
+		})
+	}
+}
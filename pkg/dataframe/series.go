@@ -4,13 +4,17 @@
 package dataframe
 
 import (
+	"math"
 	"sort"
 	"time"
 
 	"github.com/efficientgo/core/errors"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
 
 	"github.com/thanos-community/obslytics/pkg/series"
 )
@@ -26,28 +30,86 @@ type AggrOption struct {
 // AggrsOptions ia a collections of aggregations-related options. Determines
 // what aggregations are enabled etc..
 type AggrsOptions struct {
-	// Function to suggest the time of the first sample based on the resolution
-	// and the initial time of a series. By default, it uses time.Truncate(resolution)
-	// to normalize against the beginning of epoch.
-	initSampleTimeFunc func(time.Duration, time.Time) time.Time
-
 	Sum   AggrOption
 	Count AggrOption
 	Min   AggrOption
 	Max   AggrOption
+
+	// MetricName determines if the metric name (`__name__` label) should be exported as a column.
+	// Useful when series of multiple metrics are stored in the same dataframe.
+	MetricName AggrOption
+	// Resolution determines if the resolution of the window should be exported as a column.
+	// Useful when windows of multiple resolutions are stored in the same dataframe (see Concat).
+	Resolution AggrOption
+
+	// ExpectedCount, MaxGap and Completeness describe the quality of the samples of the window, e.g. to tell missing
+	// scrapes from low values. The scrape interval of the series is inferred as the median time between its last
+	// consecutive samples, so jittered scrapes or occasional extra samples do not change it, and is used for all
+	// windows of the series. ExpectedCount is the number of samples of the window at that interval, counted only
+	// between the first and the last sample of the series, so windows where the series starts or ends are not
+	// incomplete. Completeness is the ratio of the count to the expected count (at most 1), null if no samples are
+	// expected, and MaxGap is the maximum time in seconds between consecutive samples, ending in the window. Null if
+	// the interval is not known, e.g. for series with a single sample.
+	ExpectedCount AggrOption
+	MaxGap        AggrOption
+	Completeness  AggrOption
+
+	// EmptyWindows determines how windows without samples are exported.
+	EmptyWindows EmptyWindowsOption
+
+	// By aggregates the series with the same values of the given labels into a single row per window, the same way
+	// as PromQL aggregation `by` clause does. Other labels are dropped. Every series is aggregated in full first,
+	// e.g. count is the number of samples of all series of the group, min and max are the minimum and maximum
+	// of all their samples, and their quality columns are combined. Disabled if empty.
+	By []string
+}
+
+// EmptyWindowsMode determines how windows without samples are exported.
+type EmptyWindowsMode string
+
+const (
+	// EmptyWindowsSkip does not export windows without samples.
+	EmptyWindowsSkip EmptyWindowsMode = "skip"
+	// EmptyWindowsNull exports windows without samples with zero count and null values of other aggregations.
+	EmptyWindowsNull EmptyWindowsMode = "null"
+	// EmptyWindowsFill exports windows without samples the same way as EmptyWindowsNull does, except windows
+	// starting within the lookback after the last sample of the series. These get min and max set to the value of the
+	// last sample, as gauges do not change until the next sample. Sample times stay null, as there is no sample in the
+	// window. Stale markers end the series, so the windows following them are not filled.
+	EmptyWindowsFill EmptyWindowsMode = "fill"
+)
+
+// EmptyWindowsOption configures the export of windows without samples.
+type EmptyWindowsOption struct {
+	// Mode is EmptyWindowsSkip if empty.
+	Mode EmptyWindowsMode
+	// Lookback is the maximum time between the last sample and the start of the window filled by EmptyWindowsFill.
+	Lookback time.Duration
+	// MinTime and MaxTime is the time range (inclusive) to export empty windows for, if set. Otherwise only windows
+	// between the first and the last sample of every series are exported.
+	MinTime, MaxTime time.Time
+}
+
+func (o EmptyWindowsOption) enabled() bool {
+	return o.Mode == EmptyWindowsNull || o.Mode == EmptyWindowsFill
 }
 
 // By default, all aggregations are disabled and target columns set with `_` prefix.
 func defaultSeriesAggrsOptions() AggrsOptions {
 	return AggrsOptions{
-		initSampleTimeFunc: func(res time.Duration, t time.Time) time.Time {
-			return t.Truncate(res)
-		},
-
 		Sum:   AggrOption{Column: "_sum"},
 		Count: AggrOption{Column: "_count"},
 		Min:   AggrOption{Column: "_min"},
 		Max:   AggrOption{Column: "_max"},
+
+		MetricName: AggrOption{Column: labels.MetricName},
+		Resolution: AggrOption{Column: "_resolution"},
+
+		ExpectedCount: AggrOption{Column: "_expected_count"},
+		MaxGap:        AggrOption{Column: "_max_gap_seconds"},
+		Completeness:  AggrOption{Column: "_completeness"},
+
+		EmptyWindows: EmptyWindowsOption{Mode: EmptyWindowsSkip},
 	}
 }
 
@@ -72,82 +134,247 @@ type aggregatedSeries struct {
 	min         float64
 	max         float64
 	sum         float64
+
+	// last is the last sample of the series seen so far, used to fill the empty windows. Nil if the series
+	// was marked stale.
+	last *lastSample
+
+	// prevTime is the time of the previous sample of the series, zero if none. Unlike last, it is kept after
+	// stale markers, so the gap of the missing samples is known.
+	prevTime time.Time
+	// maxGap is the maximum time between consecutive samples of the series, ending in the window.
+	maxGap time.Duration
+	// interval infers the scrape interval of the series, shared by all its windows. Nil if not needed.
+	interval *intervalEstimator
+}
+
+// intervalGaps is the number of the last gaps between samples the scrape interval is inferred from.
+const intervalGaps = 16
+
+// intervalEstimator infers the scrape interval of the series as the median of the last gaps between its samples.
+// It also tracks the first and the last sample of the series, as no samples are expected outside of them.
+type intervalEstimator struct {
+	gaps [intervalGaps]time.Duration
+	n    int
+	// median is the cached median of the gaps, 0 if not computed yet.
+	median      time.Duration
+	first, last time.Time
+}
+
+// add records the time of the next sample of the series.
+func (e *intervalEstimator) add(t time.Time) {
+	switch {
+	case e.first.IsZero():
+		e.first = t
+	case t.After(e.last):
+		e.gaps[e.n%intervalGaps] = t.Sub(e.last)
+		e.n++
+		e.median = 0
+	}
+	e.last = t
+}
+
+// interval returns the inferred scrape interval, 0 if not known.
+func (e *intervalEstimator) interval() time.Duration {
+	if e == nil || e.n == 0 {
+		return 0
+	}
+	if e.median == 0 {
+		gaps := e.gaps
+		n := e.n
+		if n > intervalGaps {
+			n = intervalGaps
+		}
+		sorted := gaps[:n]
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		e.median = sorted[n/2]
+	}
+	return e.median
+}
+
+type lastSample struct {
+	t time.Time
+	v float64
 }
 
 type seriesAggregator struct {
 	df         *seriesDataframe
-	resolution time.Duration
+	resolution Resolution
 	options    AggrsOptions
+
+	// The same series can be split between multiple, not necessarily consecutive iterations (e.g. when the time
+	// range was read in multiple requests). Keep the last, not yet finalized window of every series, so windows
+	// straddling such split are aggregated into a single row.
+	openSeries map[uint64]*aggregatedSeries
+	// openOrder is the order of the open series as they were seen first.
+	openOrder []uint64
+}
+
+func newSeriesAggregator(resolution Resolution, options AggrsOptions) *seriesAggregator {
+	return &seriesAggregator{
+		resolution: resolution,
+		options:    options,
+		df:         &seriesDataframe{resolution: resolution.String(), seriesRecordSets: make(map[uint64]*seriesRecordSet)},
+		openSeries: make(map[uint64]*aggregatedSeries),
+	}
 }
 
 // IteratorFromSeries returns iterator that produce dataframe for every series.
 // TODO(bwplotka): Dataframe allows us to do bit more streaming approach. Consider this.
-func FromSeries(r series.Set, resolution time.Duration, opts ...AggrOptionFunc) (Dataframe, error) {
+func FromSeries(r series.Set, resolution Resolution, opts ...AggrOptionFunc) (Dataframe, error) {
+	dfs, err := FromSeriesResolutions(r, []Resolution{resolution}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return dfs[0], nil
+}
+
+// FromSeriesResolutions returns a dataframe for every given resolution, the same way as FromSeries does. The series
+// are iterated only once, samples of every series are buffered in memory to be aggregated in all resolutions.
+func FromSeriesResolutions(r series.Set, resolutions []Resolution, opts ...AggrOptionFunc) ([]Dataframe, error) {
 	defer r.Close()
 
-	// TODO(bwplotka): What if resolution is 0?
-	a := &seriesAggregator{
-		resolution: resolution,
-		options:    *evalOptions(opts),
-		df:         &seriesDataframe{seriesRecordSets: make(map[uint64]*seriesRecordSet)},
+	options := *evalOptions(opts)
+	aggrs := make([]*seriesAggregator, 0, len(resolutions))
+	for _, res := range resolutions {
+		if err := validateResolution(res); err != nil {
+			return nil, err
+		}
+		aggrs = append(aggrs, newSeriesAggregator(res, options))
+	}
+	if len(aggrs) == 0 {
+		return nil, errors.New("no resolution given")
 	}
 
-	var activeSeries *aggregatedSeries
-	var currentHash uint64
 	for r.Next() {
 		s := r.At()
-		ls := s.Labels()
-		seriesHash := ls.Hash()
-
-		i := s.Iterator()
-		if !i.Next() {
-			// Series without samples.
-			if err := i.Err(); err != nil {
+		if len(aggrs) == 1 {
+			if err := aggrs[0].add(s.Labels(), s.Iterator()); err != nil {
 				return nil, err
 			}
 			continue
 		}
 
-		if currentHash != seriesHash {
-			if activeSeries != nil {
-				_ = a.finalizeSample(activeSeries, activeSeries.sampleEnd)
+		samples, err := bufferSamples(s.Iterator())
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range aggrs {
+			if err := a.add(s.Labels(), storage.NewListSeries(s.Labels(), samples).Iterator()); err != nil {
+				return nil, err
 			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
 
-			mint, _ := i.At()
-			sampleStart := a.options.initSampleTimeFunc(resolution, timestamp.Time(mint))
-			sampleEnd := sampleStart.Add(resolution)
-
-			activeSeries = &aggregatedSeries{labels: ls, hash: seriesHash, sampleStart: sampleStart, sampleEnd: sampleEnd}
-			currentHash = seriesHash
+	dfs := make([]Dataframe, 0, len(aggrs))
+	for _, a := range aggrs {
+		df, err := a.finish()
+		if err != nil {
+			return nil, err
 		}
+		dfs = append(dfs, df)
+	}
+	return dfs, nil
+}
 
-		if !i.Seek(timestamp.FromTime(activeSeries.sampleStart)) {
-			// No chunks after the sampleStart to process.
-			if err := i.Err(); err != nil {
-				return nil, err
-			}
-			continue
+// add aggregates samples of the series.
+func (a *seriesAggregator) add(ls labels.Labels, i chunkenc.Iterator) error {
+	seriesHash := ls.Hash()
+	if !i.Next() {
+		// Series without samples.
+		return i.Err()
+	}
+
+	activeSeries, ok := a.openSeries[seriesHash]
+	if !ok {
+		mint, _ := i.At()
+		first := timestamp.Time(mint)
+		if ew := a.options.EmptyWindows; ew.enabled() && !ew.MinTime.IsZero() && ew.MinTime.Before(first) {
+			// Start with the first window of the time range, so the empty windows before the first sample are exported.
+			first = ew.MinTime
 		}
+		sampleStart := a.resolution.WindowStart(first)
+		sampleEnd := a.resolution.WindowEnd(sampleStart)
 
-		if err := a.ingestSamples(activeSeries, i); err != nil {
-			return nil, errors.Wrap(err, "aggregating samples")
+		rowLabels := ls
+		if len(a.options.By) > 0 {
+			rowLabels = ls.MatchLabels(true, a.options.By...)
 		}
+		activeSeries = &aggregatedSeries{labels: rowLabels, hash: rowLabels.Hash(), sampleStart: sampleStart, sampleEnd: sampleEnd}
+		if a.options.ExpectedCount.Enabled || a.options.Completeness.Enabled {
+			activeSeries.interval = &intervalEstimator{}
+		}
+		// Keep the order of series (or groups) as they were seen first.
+		a.df.addRecordSet(rowLabels)
+		a.openOrder = append(a.openOrder, seriesHash)
+	}
+
+	if !i.Seek(timestamp.FromTime(activeSeries.sampleStart)) {
+		// No chunks after the sampleStart to process.
+		a.openSeries[seriesHash] = activeSeries
+		return i.Err()
 	}
 
-	if activeSeries != nil {
-		_ = a.finalizeSample(activeSeries, activeSeries.sampleEnd)
+	activeSeries, err := a.ingestSamples(activeSeries, i)
+	if err != nil {
+		return errors.Wrap(err, "aggregating samples")
+	}
+	a.openSeries[seriesHash] = activeSeries
+	return nil
+}
+
+// finish finalizes the windows of all series and returns the dataframe.
+func (a *seriesAggregator) finish() (Dataframe, error) {
+	for _, h := range a.openOrder {
+		if as, ok := a.openSeries[h]; ok {
+			if ew := a.options.EmptyWindows; ew.enabled() && !ew.MaxTime.Before(as.sampleEnd) {
+				// Export the empty windows after the last sample, up to the end of the time range.
+				as = a.finalizeSample(as, ew.MaxTime)
+			}
+			_ = a.finalizeSample(as, as.sampleEnd)
+		}
+	}
+	if len(a.options.By) > 0 {
+		a.df.addGroupedRecords()
 	}
 
 	// We postpone the schema calculation to the time just before sending the df out
 	// so that we can use the ingested data to determine the labels to be exported.
-	a.df.schema = a.getSchema()
-	return a.df, r.Err()
+	labelNames := a.getLabelNames()
+	schema, err := seriesSchema(labelNames, a.options)
+	if err != nil {
+		return nil, err
+	}
+	return a.df.build(schema, labelNames, a.options)
+}
+
+// bufferSamples reads all samples of the iterator into memory.
+func bufferSamples(i chunkenc.Iterator) ([]tsdbutil.Sample, error) {
+	var samples []tsdbutil.Sample
+	for i.Next() {
+		t, v := i.At()
+		samples = append(samples, sample{t: t, v: v})
+	}
+	return samples, i.Err()
 }
 
+// sample implements tsdbutil.Sample.
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
+
 // ingestSamples ingests samples provided via an iterator for single series. We
-// assume the iterator returns values ordered by the timestamp.
+// assume the iterator returns values ordered by the timestamp. Stale markers are not aggregated.
 // The iterator is expected to already be at the point of the first sample after as.sampleStart.
-func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterator) error {
+// Returns the aggregated series of the last, not yet finalized window.
+func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterator) (*aggregatedSeries, error) {
 	var (
 		ts int64
 		v  float64
@@ -157,11 +384,32 @@ func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterat
 		ts, v = i.At()
 		t = timestamp.Time(ts)
 		if t.Before(as.sampleStart) {
-			return errors.Newf("Chunk timestamp %s is less than the sampleStart %s", t, as.sampleStart)
+			return nil, errors.Newf("Chunk timestamp %s is less than the sampleStart %s", t, as.sampleStart)
 		}
-		if t.After(as.sampleEnd) {
+		if !t.Before(as.sampleEnd) {
+			// Windows are half-open, the sample at the end belongs to the next window.
 			as = a.finalizeSample(as, t)
 		}
+		if value.IsStaleNaN(v) {
+			// The series ended, e.g. its target is down.
+			as.last = nil
+			if !i.Next() {
+				return as, i.Err()
+			}
+			continue
+		}
+
+		if !as.prevTime.IsZero() {
+			if gap := t.Sub(as.prevTime); gap > 0 {
+				if gap > as.maxGap {
+					as.maxGap = gap
+				}
+			}
+		}
+		as.prevTime = t
+		if as.interval != nil {
+			as.interval.add(t)
+		}
 
 		if as.count == 0 {
 			as.minTime = t
@@ -170,9 +418,10 @@ func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterat
 			as.max = v
 		}
 		if as.maxTime.After(t) {
-			return errors.Newf("Incoming chunks are not sorted by timestamp: expected %s after %s", t, as.maxTime)
+			return nil, errors.Newf("Incoming chunks are not sorted by timestamp: expected %s after %s", t, as.maxTime)
 		}
 		as.maxTime = t
+		as.last = &lastSample{t: t, v: v}
 		as.count += 1
 		as.sum += v
 		if as.max < v {
@@ -182,29 +431,45 @@ func (a *seriesAggregator) ingestSamples(as *aggregatedSeries, i chunkenc.Iterat
 			as.min = v
 		}
 		if !i.Next() {
-			return i.Err()
+			return as, i.Err()
 		}
 	}
 }
 
 // finalizeSample adds the active aggregated series into the final dataframe when we've reached the
-// sample end time. Returns pointer to a new instance of the aggregatedSeries.
+// sample end time, together with the empty windows before the window of nextT, if enabled.
+// Returns pointer to a new instance of the aggregatedSeries.
 func (a *seriesAggregator) finalizeSample(as *aggregatedSeries, nextT time.Time) *aggregatedSeries {
-	if as.count > 0 {
+	exportEmpty := a.options.EmptyWindows.enabled()
+	if as.count > 0 || exportEmpty {
 		a.df.addSeries(as, a.options)
 	}
 
-	// calculate the next sample cycle to contain the nextT time. First calculate how many
-	// whole resolution cycles are between current sampleStart and nextT and then add
-	// those cycles to the current sampleStart.
-	nextSampleCycle := (nextT.Unix() - as.sampleStart.Unix()) / (int64)(a.resolution/time.Second)
-	nextSampleStart := as.sampleStart.Add((time.Duration(nextSampleCycle)) * a.resolution)
+	// The next sample cycle is the window containing the nextT time.
+	nextSampleStart := a.resolution.WindowStart(nextT)
+
+	if exportEmpty {
+		for start := as.sampleEnd; start.Before(nextSampleStart); start = a.resolution.WindowEnd(start) {
+			a.df.addSeries(&aggregatedSeries{
+				labels:      as.labels,
+				hash:        as.hash,
+				sampleStart: start,
+				sampleEnd:   a.resolution.WindowEnd(start),
+				last:        as.last,
+				prevTime:    as.prevTime,
+				interval:    as.interval,
+			}, a.options)
+		}
+	}
 
 	return &aggregatedSeries{
 		labels:      as.labels,
 		hash:        as.hash,
 		sampleStart: nextSampleStart,
-		sampleEnd:   nextSampleStart.Add(a.resolution),
+		sampleEnd:   a.resolution.WindowEnd(nextSampleStart),
+		last:        as.last,
+		prevTime:    as.prevTime,
+		interval:    as.interval,
 	}
 }
 
@@ -229,7 +494,7 @@ func (a *seriesAggregator) getLabelNames() []string {
 	ls = labels.FromMap(lsMap)
 
 	for _, l := range ls {
-		if l.Name == "__name__" {
+		if l.Name == labels.MetricName {
 			continue
 		}
 		ret = append(ret, l.Name)
@@ -238,24 +503,53 @@ func (a *seriesAggregator) getLabelNames() []string {
 	return ret
 }
 
-func (a *seriesAggregator) getSchema() Schema {
-	ao := a.options
-	schema := Schema{}
+// SeriesSchema returns the schema of the dataframe FromSeries produces from series with the given label names.
+// Useful to know the columns upfront, without reading the samples.
+func SeriesSchema(labelNames []string, opts ...AggrOptionFunc) (Schema, error) {
+	var names []string
+	for _, l := range labelNames {
+		if l != labels.MetricName {
+			names = append(names, l)
+		}
+	}
+	sort.Strings(names)
+	return seriesSchema(names, *evalOptions(opts))
+}
 
-	for _, l := range a.getLabelNames() {
+// seriesSchema expects sorted label names without the metric name.
+func seriesSchema(labelNames []string, ao AggrsOptions) (Schema, error) {
+	schema := Schema{}
+	if ao.MetricName.Enabled {
+		for _, l := range labelNames {
+			if l == ao.MetricName.Column {
+				return nil, errors.Newf("metric name column %q conflicts with the series label of the same name", l)
+			}
+		}
+		schema = append(schema, Column{Name: ao.MetricName.Column, Type: TypeString, Required: true})
+	}
+	for _, l := range labelNames {
 		schema = append(schema, Column{Name: l, Type: TypeString})
 	}
+	if ao.Resolution.Enabled {
+		for _, l := range labelNames {
+			if l == ao.Resolution.Column {
+				return nil, errors.Newf("resolution column %q conflicts with the series label of the same name", l)
+			}
+		}
+		schema = append(schema, Column{Name: ao.Resolution.Column, Type: TypeString, Required: true})
+	}
 
+	// Windows are always set, times of the samples are null in empty windows.
 	timeColumns := []Column{
-		{Name: "_sample_start", Type: TypeTime},
-		{Name: "_sample_end", Type: TypeTime},
+		{Name: "_sample_start", Type: TypeTime, Required: true},
+		{Name: "_sample_end", Type: TypeTime, Required: true},
 		{Name: "_min_time", Type: TypeTime},
 		{Name: "_max_time", Type: TypeTime},
 	}
 	schema = append(schema, timeColumns...)
 
 	if ao.Count.Enabled {
-		schema = append(schema, Column{Name: ao.Count.Column, Type: TypeUint})
+		schema = append(schema, Column{Name: ao.Count.Column, Type: TypeUint, Required: true})
 	}
 	if ao.Sum.Enabled {
 		schema = append(schema, Column{Name: ao.Sum.Column, Type: TypeFloat})
@@ -266,13 +560,22 @@ func (a *seriesAggregator) getSchema() Schema {
 	if ao.Max.Enabled {
 		schema = append(schema, Column{Name: ao.Max.Column, Type: TypeFloat})
 	}
+	if ao.ExpectedCount.Enabled {
+		schema = append(schema, Column{Name: ao.ExpectedCount.Column, Type: TypeUint})
+	}
+	if ao.MaxGap.Enabled {
+		schema = append(schema, Column{Name: ao.MaxGap.Column, Type: TypeFloat})
+	}
+	if ao.Completeness.Enabled {
+		schema = append(schema, Column{Name: ao.Completeness.Column, Type: TypeFloat})
+	}
 
-	return schema
+	return schema, nil
 }
 
-// seriesDataframe implements dataframe.Dataframe.
+// seriesDataframe collects the windows of every series (or group) to build the dataframe from.
 type seriesDataframe struct {
-	schema           Schema
+	resolution       string
 	seriesRecordSets map[uint64]*seriesRecordSet
 	seriesOrder      []uint64
 }
@@ -283,101 +586,337 @@ func (df *seriesDataframe) addSeries(as *aggregatedSeries, opts AggrsOptions) {
 		rs = df.addRecordSet(as.labels)
 	}
 
-	vals := map[string]interface{}{
-		"_sample_start": as.sampleStart,
-		"_sample_end":   as.sampleEnd,
-		"_min_time":     as.minTime,
-		"_max_time":     as.maxTime,
+	w := newWindowValues(as, opts)
+	if len(opts.By) == 0 {
+		rs.records = append(rs.records, windowRecord{start: as.sampleStart, end: as.sampleEnd, values: w})
+		return
 	}
 
-	for _, l := range as.labels {
-		if l.Name == "__name__" {
-			continue
+	// Windows of the series of the group are merged once all series are aggregated.
+	if rs.windows == nil {
+		rs.windows = map[int64]*windowRecord{}
+	}
+	key := timestamp.FromTime(as.sampleStart)
+	if gw, ok := rs.windows[key]; ok {
+		gw.values.merge(w)
+		return
+	}
+	rs.windows[key] = &windowRecord{start: as.sampleStart, end: as.sampleEnd, values: w}
+}
+
+// addGroupedRecords adds the records of the merged windows of every group, ordered by the window start.
+func (df *seriesDataframe) addGroupedRecords() {
+	for _, h := range df.seriesOrder {
+		rs := df.seriesRecordSets[h]
+		windows := make([]*windowRecord, 0, len(rs.windows))
+		for _, gw := range rs.windows {
+			windows = append(windows, gw)
 		}
-		vals[l.Name] = l.Value
+		sort.Slice(windows, func(i, j int) bool { return windows[i].start.Before(windows[j].start) })
+		for _, gw := range windows {
+			rs.records = append(rs.records, *gw)
+		}
+		rs.windows = nil
 	}
+}
 
-	if opts.Count.Enabled {
-		vals[opts.Count.Column] = as.count
+// maxBatchRows is the maximum number of rows of the batches built from series, bounding the memory of consumers
+// processing a whole batch at once, e.g. the Parquet encoder.
+var maxBatchRows = 8192
+
+// recordVectors are the vectors of a batch the records are appended to. Vectors of disabled aggregations are nil.
+type recordVectors struct {
+	sampleStart, sampleEnd, minTime, maxTime *TimeVector
+	metricName, resolution                   *StringVector
+	count, expected                          *UintVector
+	sum, min, max, maxGap, completion        *FloatVector
+	labels                                   []*StringVector
+}
+
+// newRecordVectors returns the batch for the given number of rows and its vectors.
+func newRecordVectors(schema Schema, labelNames []string, opts AggrsOptions, rows int) (Batch, recordVectors, error) {
+	b, err := newBatch(schema)
+	if err != nil {
+		return Batch{}, recordVectors{}, err
 	}
-	if opts.Sum.Enabled {
-		vals[opts.Sum.Column] = as.sum
+	for _, c := range b.Columns {
+		reserve(c, rows)
 	}
-	if opts.Min.Enabled {
-		vals[opts.Min.Column] = as.min
+
+	enabled := func(column string) AggrOption { return AggrOption{Enabled: true, Column: column} }
+	v := recordVectors{
+		sampleStart: vectorOf[time.Time](b, schema, enabled("_sample_start")),
+		sampleEnd:   vectorOf[time.Time](b, schema, enabled("_sample_end")),
+		minTime:     vectorOf[time.Time](b, schema, enabled("_min_time")),
+		maxTime:     vectorOf[time.Time](b, schema, enabled("_max_time")),
+		metricName:  vectorOf[string](b, schema, opts.MetricName),
+		resolution:  vectorOf[string](b, schema, opts.Resolution),
+		count:       vectorOf[uint64](b, schema, opts.Count),
+		sum:         vectorOf[float64](b, schema, opts.Sum),
+		min:         vectorOf[float64](b, schema, opts.Min),
+		max:         vectorOf[float64](b, schema, opts.Max),
+		expected:    vectorOf[uint64](b, schema, opts.ExpectedCount),
+		maxGap:      vectorOf[float64](b, schema, opts.MaxGap),
+		completion:  vectorOf[float64](b, schema, opts.Completeness),
+		labels:      make([]*StringVector, 0, len(labelNames)),
 	}
-	if opts.Max.Enabled {
-		vals[opts.Max.Column] = as.max
+	for _, l := range labelNames {
+		v.labels = append(v.labels, vectorOf[string](b, schema, enabled(l)))
 	}
-	rs.Records = append(rs.Records, Record{Values: vals})
+	return b, v, nil
 }
 
-// Initiate new recordset for specific label.
-func (df *seriesDataframe) addRecordSet(ls labels.Labels) *seriesRecordSet {
-	rs := &seriesRecordSet{Labels: ls, Records: make([]Record, 0)}
-	hash := ls.Hash()
-	df.seriesRecordSets[hash] = rs
-	df.seriesOrder = append(df.seriesOrder, hash)
-	return rs
+// build returns the dataframe with the records of all series, in the order the series were seen first, in batches of
+// at most maxBatchRows rows. The label names are the label columns of the schema.
+func (df *seriesDataframe) build(schema Schema, labelNames []string, opts AggrsOptions) (Dataframe, error) {
+	var rows int
+	for _, rs := range df.seriesRecordSets {
+		rows += len(rs.records)
+	}
+	if rows == 0 {
+		return FromBatches(schema), nil
+	}
+
+	var (
+		batches     = make([]Batch, 0, (rows+maxBatchRows-1)/maxBatchRows)
+		b           Batch
+		v           recordVectors
+		labelValues = make([]string, len(labelNames))
+	)
+	for _, h := range df.seriesOrder {
+		rs := df.seriesRecordSets[h]
+		for i, l := range labelNames {
+			labelValues[i] = rs.Labels.Get(l)
+		}
+		name := rs.Labels.Get(labels.MetricName)
+
+		for _, r := range rs.records {
+			if b.Len() == 0 || b.Len() == maxBatchRows {
+				// Reserve the rows left, up to the batch size.
+				n := rows
+				if n > maxBatchRows {
+					n = maxBatchRows
+				}
+				var err error
+				if b, v, err = newRecordVectors(schema, labelNames, opts, n); err != nil {
+					return nil, err
+				}
+				batches = append(batches, b)
+				rows -= n
+			}
+
+			// Labels missing in some series are null.
+			for i, lv := range v.labels {
+				appendNullable(lv, labelValues[i], labelValues[i] != "")
+			}
+			appendNullable(v.metricName, name, true)
+			appendNullable(v.resolution, df.resolution, true)
+
+			w := r.values
+			v.sampleStart.Append(r.start)
+			v.sampleEnd.Append(r.end)
+			// Values of empty windows are null, except for the count.
+			appendNullable(v.minTime, w.minTime, w.has(hasTimes))
+			appendNullable(v.maxTime, w.maxTime, w.has(hasTimes))
+			appendNullable(v.count, w.count, true)
+			appendNullable(v.sum, w.sum, w.has(hasSum))
+			appendNullable(v.min, w.min, w.has(hasMin))
+			appendNullable(v.max, w.max, w.has(hasMax))
+			e, known := w.expectedCount(r.start, r.end)
+			appendNullable(v.expected, uint64(e), known)
+			appendNullable(v.maxGap, w.maxGap, w.has(hasMaxGap))
+			// Completeness of windows without expected samples, e.g. before the first sample of the series, is null.
+			appendNullable(v.completion, math.Min(1, float64(w.count)/e), known && e > 0)
+		}
+		// Records are not needed anymore.
+		rs.records = nil
+	}
+	return FromBatches(schema, batches...), nil
 }
 
-func (df seriesDataframe) Schema() Schema {
-	return df.schema
+// vectorOf returns the vector of the column of the aggregation, nil if the aggregation is disabled.
+func vectorOf[T any](b Batch, schema Schema, o AggrOption) *Vector[T] {
+	if !o.Enabled {
+		return nil
+	}
+	return b.Columns[columnIndex(schema, o.Column)].(*Vector[T])
 }
 
-func (df seriesDataframe) RowsIterator() RowsIterator {
-	rs := make([]seriesRecordSet, 0, len(df.seriesRecordSets))
-	for _, v := range df.seriesOrder {
-		rs = append(rs, *df.seriesRecordSets[v])
+// appendNullable appends the value to the vector, or null if not valid. Noop if the vector is nil.
+func appendNullable[T any](v *Vector[T], x T, valid bool) {
+	switch {
+	case v == nil:
+	case valid:
+		v.Append(x)
+	default:
+		v.AppendNull()
 	}
-	return &seriesDataframeRowIterator{seriesRecordSets: rs, schema: df.schema, seriesPos: 0, recordPos: -1}
 }
 
-// seriesDataframeRowIterator implements dataframe.RowIterator.
-type seriesDataframeRowIterator struct {
-	seriesRecordSets []seriesRecordSet
-	schema           Schema
-	seriesPos        int
-	recordPos        int
+// reserve grows the capacity of the vector to the given number of values.
+func reserve(c ColumnVector, n int) {
+	switch v := c.(type) {
+	case *StringVector:
+		v.Values = make([]string, 0, n)
+	case *FloatVector:
+		v.Values = make([]float64, 0, n)
+	case *UintVector:
+		v.Values = make([]uint64, 0, n)
+	case *TimeVector:
+		v.Values = make([]time.Time, 0, n)
+	}
 }
 
-func (i *seriesDataframeRowIterator) Next() bool {
-	if len(i.seriesRecordSets) == 0 {
-		return false
+// valueFlags mark the values of the window which are not null.
+type valueFlags uint8
+
+const (
+	hasTimes valueFlags = 1 << iota
+	hasSum
+	hasMin
+	hasMax
+	hasMaxGap
+)
+
+// windowValues are the aggregated values of a window of one or more series. Values not marked in set are null.
+type windowValues struct {
+	set              valueFlags
+	count            uint64
+	sum, min, max    float64
+	minTime, maxTime time.Time
+	maxGap           float64
+	// intervals infer the scrape interval of every series of the window.
+	intervals []*intervalEstimator
+}
+
+func (w windowValues) has(f valueFlags) bool { return w.set&f != 0 }
+
+// expectedCount returns the number of samples expected in the window, summed across the series with known scrape
+// interval. Returns false if no interval is known.
+func (w windowValues) expectedCount(start, end time.Time) (float64, bool) {
+	var (
+		expected float64
+		known    bool
+	)
+	for _, e := range w.intervals {
+		if interval := e.interval(); interval > 0 {
+			expected += e.expectedCount(start, end, interval)
+			known = true
+		}
 	}
-	s := i.seriesRecordSets[i.seriesPos]
+	return expected, known
+}
 
-	if i.recordPos < len(s.Records)-1 {
-		i.recordPos += 1
-		return true
+// expectedCount returns the number of samples of the series expected in the window at the interval. Samples are
+// expected only between the first and the last sample of the series, so windows where the series starts or ends
+// (including the windows at the edges of the time range read) are not reported as incomplete.
+func (e *intervalEstimator) expectedCount(start, end time.Time, interval time.Duration) float64 {
+	var (
+		hasFirst = !e.first.Before(start) && e.first.Before(end)
+		hasLast  = !e.last.Before(start) && e.last.Before(end)
+		i        = float64(interval)
+	)
+	switch {
+	case !e.first.Before(end) || e.last.Before(start):
+		// The series has no samples in or around the window.
+		return 0
+	case hasFirst && hasLast:
+		return math.Round(float64(e.last.Sub(e.first))/i) + 1
+	case hasFirst:
+		// Samples at the interval from the first sample up to the end of the window.
+		return math.Ceil(float64(end.Sub(e.first)) / i)
+	case hasLast:
+		// Samples at the interval from the start of the window up to the last sample.
+		return math.Floor(float64(e.last.Sub(start))/i) + 1
+	default:
+		// Windows shorter than the interval expect a single sample, e.g. of every other window.
+		return math.Max(1, math.Round(float64(end.Sub(start))/i))
 	}
+}
 
-	if i.seriesPos < len(i.seriesRecordSets)-1 {
-		i.seriesPos += 1
-		i.recordPos = 0
-		return true
+func newWindowValues(as *aggregatedSeries, opts AggrsOptions) windowValues {
+	w := windowValues{count: as.count}
+	switch {
+	case as.count > 0:
+		w.set |= hasTimes | hasSum | hasMin | hasMax
+		w.minTime, w.maxTime, w.sum, w.min, w.max = as.minTime, as.maxTime, as.sum, as.min, as.max
+		if as.maxGap > 0 {
+			w.set |= hasMaxGap
+			w.maxGap = as.maxGap.Seconds()
+		}
+	case opts.EmptyWindows.Mode == EmptyWindowsFill && as.last != nil && as.sampleStart.Sub(as.last.t) <= opts.EmptyWindows.Lookback:
+		// Forward-fill the last sample. Sum is left null as there are no samples to sum, sample times are left null as
+		// the last sample lies outside of the window.
+		w.set |= hasMin | hasMax
+		w.min, w.max = as.last.v, as.last.v
 	}
+	if as.interval != nil {
+		// The expected count is known once all samples of the series are ingested, see expectedCount.
+		w.intervals = []*intervalEstimator{as.interval}
+	}
+	return w
+}
 
-	return false
+// merge merges the values of the same window of another series.
+func (w *windowValues) merge(o windowValues) {
+	w.count += o.count
+	mergeNullable(w, o, hasSum, &w.sum, o.sum, func(a, b float64) float64 { return a + b })
+	mergeNullable(w, o, hasMin, &w.min, o.min, math.Min)
+	mergeNullable(w, o, hasMax, &w.max, o.max, math.Max)
+	w.intervals = append(w.intervals, o.intervals...)
+	mergeNullable(w, o, hasMaxGap, &w.maxGap, o.maxGap, math.Max)
+	// Both times are null in empty windows.
+	switch {
+	case !o.has(hasTimes):
+	case !w.has(hasTimes):
+		w.minTime, w.maxTime = o.minTime, o.maxTime
+		w.set |= hasTimes
+	default:
+		if o.minTime.Before(w.minTime) {
+			w.minTime = o.minTime
+		}
+		if o.maxTime.After(w.maxTime) {
+			w.maxTime = o.maxTime
+		}
+	}
 }
 
-func (i *seriesDataframeRowIterator) At() Row {
-	s := i.seriesRecordSets[i.seriesPos]
-	ret := make([]interface{}, 0, len(i.schema))
-	vals := s.Records[i.recordPos].Values
-	for _, c := range i.schema {
-		ret = append(ret, vals[c.Name])
+// mergeNullable merges the value of the flag with f, if both are not null.
+func mergeNullable[T any](w *windowValues, o windowValues, flag valueFlags, a *T, b T, f func(T, T) T) {
+	switch {
+	case !o.has(flag):
+	case !w.has(flag):
+		*a = b
+		w.set |= flag
+	default:
+		*a = f(*a, b)
 	}
-	return ret
+}
+
+// Initiate new recordset for specific label, unless it exists already.
+func (df *seriesDataframe) addRecordSet(ls labels.Labels) *seriesRecordSet {
+	hash := ls.Hash()
+	if rs, ok := df.seriesRecordSets[hash]; ok {
+		return rs
+	}
+	rs := &seriesRecordSet{Labels: ls}
+	df.seriesRecordSets[hash] = rs
+	df.seriesOrder = append(df.seriesOrder, hash)
+	return rs
 }
 
 // seriesRecordSet is a set of records for specific labels values.
 type seriesRecordSet struct {
 	Labels  labels.Labels
-	Records []Record
+	records []windowRecord
+
+	// windows of the series of the group, by the window start in milliseconds, merged into records once all series
+	// are aggregated. Only used with AggrsOptions.By.
+	windows map[int64]*windowRecord
 }
 
-// Record is a single instance of values for specific sample.
-type Record struct {
-	Values map[string]interface{}
+// windowRecord is the aggregated window of the series.
+type windowRecord struct {
+	start, end time.Time
+	values     windowValues
 }
@@ -0,0 +1,392 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func newTestSeries(lset labels.Labels, smpls ...sample) storage.Series {
+	ss := make([]tsdbutil.Sample, 0, len(smpls))
+	for _, s := range smpls {
+		ss = append(ss, s)
+	}
+	return storage.NewListSeries(lset, ss)
+}
+
+func enableAllAggrs(o *AggrsOptions) {
+	o.Count.Enabled = true
+	o.Sum.Enabled = true
+	o.Min.Enabled = true
+	o.Max.Enabled = true
+}
+
+func TestFromSeries_MultipleWindows(t *testing.T) {
+	df, err := FromSeries(series.NewListSet(
+		newTestSeries(labels.FromStrings("instance", "a"), sample{t: 10000, v: 1}, sample{t: 70000, v: 2}, sample{t: 130000, v: 3}),
+	), FixedResolution(time.Minute), enableAllAggrs)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:00:00       00:01:00     00:00:10   00:00:10   1       1     1     1     |
+| a         00:01:00       00:02:00     00:01:10   00:01:10   1       2     2     2     |
+| a         00:02:00       00:03:00     00:02:10   00:02:10   1       3     3     3     |
+`, ToString(df))
+}
+
+func TestFromSeries_MetricName(t *testing.T) {
+	newSet := func() series.Set {
+		return series.NewListSet(
+			newTestSeries(labels.FromStrings("__name__", "node_load1", "instance", "a"), sample{t: 1000, v: 1}, sample{t: 2000, v: 3}),
+			newTestSeries(labels.FromStrings("__name__", "node_load5", "instance", "a"), sample{t: 1000, v: 2}),
+		)
+	}
+
+	t.Run("metric name dropped by default", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs)
+		testutil.Ok(t, err)
+		testutil.Equals(t, "instance", df.Schema()[0].Name)
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:00:00       00:01:00     00:00:01   00:00:02   2       4     1     3     |
+| a         00:00:00       00:01:00     00:00:01   00:00:01   1       2     2     2     |
+`, ToString(df))
+	})
+	t.Run("metric name column", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs, func(o *AggrsOptions) {
+			o.MetricName.Enabled = true
+			o.MetricName.Column = "metric"
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| metric      instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| node_load1  a         00:00:00       00:01:00     00:00:01   00:00:02   2       4     1     3     |
+| node_load5  a         00:00:00       00:01:00     00:00:01   00:00:01   1       2     2     2     |
+`, ToString(df))
+	})
+	t.Run("metric name column conflicting with label", func(t *testing.T) {
+		_, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs, func(o *AggrsOptions) {
+			o.MetricName.Enabled = true
+			o.MetricName.Column = "instance"
+		})
+		testutil.NotOk(t, err)
+	})
+}
+
+func TestFromSeries_SplitSeries(t *testing.T) {
+	a := labels.FromStrings("instance", "a")
+	b := labels.FromStrings("instance", "b")
+
+	// Series split between two reads in the middle of the 00:01:00-00:02:00 window.
+	df, err := FromSeries(series.NewListSet(
+		newTestSeries(a, sample{t: 30000, v: 1}, sample{t: 70000, v: 2}),
+		newTestSeries(b, sample{t: 70000, v: 5}),
+		newTestSeries(a, sample{t: 100000, v: 3}, sample{t: 130000, v: 4}),
+		newTestSeries(b, sample{t: 100000, v: 6}),
+	), FixedResolution(time.Minute), enableAllAggrs)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:00:00       00:01:00     00:00:30   00:00:30   1       1     1     1     |
+| a         00:01:00       00:02:00     00:01:10   00:01:40   2       5     2     3     |
+| a         00:02:00       00:03:00     00:02:10   00:02:10   1       4     4     4     |
+| b         00:01:00       00:02:00     00:01:10   00:01:40   2       11    5     6     |
+`, ToString(df))
+}
+
+func TestSeriesSchema(t *testing.T) {
+	ls1 := labels.FromStrings(labels.MetricName, "up", "job", "a", "instance", "1")
+	ls2 := labels.FromStrings(labels.MetricName, "up", "job", "b", "zone", "z")
+	df, err := FromSeries(series.NewListSet(newTestSeries(ls1, sample{t: 0, v: 1}), newTestSeries(ls2, sample{t: 0, v: 1})), FixedResolution(time.Minute), enableAllAggrs)
+	testutil.Ok(t, err)
+
+	schema, err := SeriesSchema([]string{"zone", labels.MetricName, "job", "instance"}, enableAllAggrs)
+	testutil.Ok(t, err)
+	testutil.Equals(t, df.Schema(), schema)
+}
+
+func TestFromSeries_EmptyWindows(t *testing.T) {
+	stale := math.Float64frombits(value.StaleNaN)
+	newSet := func() series.Set {
+		// Samples in 00:00-00:01 and 00:03-00:04 windows, with target down in 00:04-00:05 window.
+		return series.NewListSet(
+			newTestSeries(labels.FromStrings("instance", "a"),
+				sample{t: 70000, v: 1}, sample{t: 100000, v: 2},
+				sample{t: 190000, v: 3}, sample{t: 250000, v: stale},
+			),
+		)
+	}
+
+	t.Run("stale markers excluded, empty windows skipped", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs)
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:01:00       00:02:00     00:01:10   00:01:40   2       3     1     2     |
+| a         00:03:00       00:04:00     00:03:10   00:03:10   1       3     3     3     |
+`, ToString(df))
+	})
+	t.Run("null", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs, func(o *AggrsOptions) {
+			o.EmptyWindows = EmptyWindowsOption{Mode: EmptyWindowsNull, MinTime: time.Unix(0, 0), MaxTime: time.Unix(330, 0)}
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:00:00       00:01:00     null       null       0       null  null  null  |
+| a         00:01:00       00:02:00     00:01:10   00:01:40   2       3     1     2     |
+| a         00:02:00       00:03:00     null       null       0       null  null  null  |
+| a         00:03:00       00:04:00     00:03:10   00:03:10   1       3     3     3     |
+| a         00:04:00       00:05:00     null       null       0       null  null  null  |
+| a         00:05:00       00:06:00     null       null       0       null  null  null  |
+`, ToString(df))
+	})
+	t.Run("fill", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs, func(o *AggrsOptions) {
+			o.EmptyWindows = EmptyWindowsOption{Mode: EmptyWindowsFill, Lookback: 5 * time.Minute, MaxTime: time.Unix(330, 0)}
+		})
+		testutil.Ok(t, err)
+		// The window following the stale marker is not filled.
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:01:00       00:02:00     00:01:10   00:01:40   2       3     1     2     |
+| a         00:02:00       00:03:00     null       null       0       null  2     2     |
+| a         00:03:00       00:04:00     00:03:10   00:03:10   1       3     3     3     |
+| a         00:04:00       00:05:00     null       null       0       null  null  null  |
+| a         00:05:00       00:06:00     null       null       0       null  null  null  |
+`, ToString(df))
+	})
+	t.Run("fill lookback", func(t *testing.T) {
+		df, err := FromSeries(series.NewListSet(
+			newTestSeries(labels.FromStrings("instance", "a"), sample{t: 10000, v: 1}, sample{t: 250000, v: 2}),
+		), FixedResolution(time.Minute), enableAllAggrs, func(o *AggrsOptions) {
+			o.EmptyWindows = EmptyWindowsOption{Mode: EmptyWindowsFill, Lookback: 2 * time.Minute}
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:00:00       00:01:00     00:00:10   00:00:10   1       1     1     1     |
+| a         00:01:00       00:02:00     null       null       0       null  1     1     |
+| a         00:02:00       00:03:00     null       null       0       null  1     1     |
+| a         00:03:00       00:04:00     null       null       0       null  null  null  |
+| a         00:04:00       00:05:00     00:04:10   00:04:10   1       2     2     2     |
+`, ToString(df))
+	})
+}
+
+func TestFromSeries_CalendarResolution(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	testutil.Ok(t, err)
+
+	// Samples at local midnights of 1st and 2nd March, and 1st April, when the daylight saving time is already on.
+	df, err := FromSeries(series.NewListSet(newTestSeries(labels.FromStrings("instance", "a"),
+		sample{t: time.Date(2022, 3, 1, 0, 0, 0, 0, prague).UnixMilli(), v: 1},
+		sample{t: time.Date(2022, 3, 2, 0, 0, 0, 0, prague).UnixMilli(), v: 2},
+		sample{t: time.Date(2022, 4, 1, 0, 0, 0, 0, prague).UnixMilli(), v: 3},
+	)), CalendarResolution{Unit: Month, Count: 1, Location: prague}, enableAllAggrs)
+	testutil.Ok(t, err)
+
+	var got [][]interface{}
+	for i := Rows(df); i.Next(); {
+		r := i.At()
+		got = append(got, []interface{}{r[1].(time.Time).UTC(), r[2].(time.Time).UTC(), r[5]})
+	}
+	testutil.Equals(t, [][]interface{}{
+		{time.Date(2022, 2, 28, 23, 0, 0, 0, time.UTC), time.Date(2022, 3, 31, 22, 0, 0, 0, time.UTC), uint64(2)},
+		{time.Date(2022, 3, 31, 22, 0, 0, 0, time.UTC), time.Date(2022, 4, 30, 22, 0, 0, 0, time.UTC), uint64(1)},
+	}, got)
+}
+
+func TestFromSeriesResolutions(t *testing.T) {
+	dfs, err := FromSeriesResolutions(series.NewListSet(
+		newTestSeries(labels.FromStrings("instance", "a"), sample{t: 30000, v: 1}, sample{t: 70000, v: 2}, sample{t: 130000, v: 3}),
+		newTestSeries(labels.FromStrings("instance", "b"), sample{t: 100000, v: 5}),
+	), []Resolution{FixedResolution(time.Minute), FixedResolution(5 * time.Minute)}, enableAllAggrs, func(o *AggrsOptions) {
+		o.Resolution.Enabled = true
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(dfs))
+
+	df, err := Concat(dfs...)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `| instance  _resolution  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         1m           00:00:00       00:01:00     00:00:30   00:00:30   1       1     1     1     |
+| a         1m           00:01:00       00:02:00     00:01:10   00:01:10   1       2     2     2     |
+| a         1m           00:02:00       00:03:00     00:02:10   00:02:10   1       3     3     3     |
+| b         1m           00:01:00       00:02:00     00:01:40   00:01:40   1       5     5     5     |
+| a         5m           00:00:00       00:05:00     00:00:30   00:02:10   3       6     1     3     |
+| b         5m           00:00:00       00:05:00     00:01:40   00:01:40   1       5     5     5     |
+`, ToString(df))
+
+	_, err = Concat(dfs[0], FromRows(Schema{{Name: "instance", Type: TypeString}}, nil))
+	testutil.NotOk(t, err)
+}
+
+func TestFromSeries_WindowBoundaries(t *testing.T) {
+	newSet := func() series.Set {
+		return series.NewListSet(newTestSeries(labels.FromStrings("instance", "a"),
+			sample{t: 0, v: 1}, sample{t: 249, v: 2}, sample{t: 250, v: 3}, sample{t: 60000, v: 4}, sample{t: 60001, v: 5},
+		))
+	}
+
+	t.Run("sample at the window end belongs to the next window", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(time.Minute), enableAllAggrs)
+		testutil.Ok(t, err)
+		testutil.Equals(t, `| instance  _sample_start  _sample_end  _min_time  _max_time  _count  _sum  _min  _max  |
+| a         00:00:00       00:01:00     00:00:00   00:00:00   3       6     1     3     |
+| a         00:01:00       00:02:00     00:01:00   00:01:00   2       9     4     5     |
+`, ToString(df))
+	})
+	t.Run("sub-second resolution", func(t *testing.T) {
+		df, err := FromSeries(newSet(), FixedResolution(250*time.Millisecond), enableAllAggrs)
+		testutil.Ok(t, err)
+
+		var got [][]interface{}
+		for i := Rows(df); i.Next(); {
+			r := i.At()
+			got = append(got, []interface{}{r[1].(time.Time).UnixMilli(), r[2].(time.Time).UnixMilli(), r[5]})
+		}
+		testutil.Equals(t, [][]interface{}{
+			{int64(0), int64(250), uint64(2)},
+			{int64(250), int64(500), uint64(1)},
+			{int64(60000), int64(60250), uint64(2)},
+		}, got)
+	})
+	t.Run("offset", func(t *testing.T) {
+		df, err := FromSeries(newSet(), WithOffset(FixedResolution(time.Minute), 250*time.Millisecond), enableAllAggrs, func(o *AggrsOptions) {
+			o.Resolution.Enabled = true
+		})
+		testutil.Ok(t, err)
+
+		var got [][]interface{}
+		for i := Rows(df); i.Next(); {
+			r := i.At()
+			got = append(got, []interface{}{r[1], r[2].(time.Time).UnixMilli(), r[3].(time.Time).UnixMilli(), r[6]})
+		}
+		testutil.Equals(t, [][]interface{}{
+			{"1m+250ms", int64(-59750), int64(250), uint64(2)},
+			{"1m+250ms", int64(250), int64(60250), uint64(3)},
+		}, got)
+	})
+	t.Run("resolution without windows", func(t *testing.T) {
+		for _, res := range []Resolution{FixedResolution(0), FixedResolution(-time.Minute), FixedResolution(time.Microsecond)} {
+			_, err := FromSeries(newSet(), res, enableAllAggrs)
+			testutil.NotOk(t, err)
+		}
+	})
+}
+
+// TestFromSeries_ExactlyOneWindow checks every sample is aggregated in exactly one window containing it,
+// for random series split into multiple reads and random resolutions.
+func TestFromSeries_ExactlyOneWindow(t *testing.T) {
+	prague, err := time.LoadLocation("Europe/Prague")
+	testutil.Ok(t, err)
+
+	// Fixed seed keeps failures reproducible.
+	r := rand.New(rand.NewSource(1))
+	resolutions := []Resolution{
+		FixedResolution(time.Millisecond),
+		FixedResolution(333 * time.Millisecond),
+		FixedResolution(time.Minute),
+		WithOffset(FixedResolution(time.Hour), 15*time.Minute),
+		CalendarResolution{Unit: Day, Count: 1, Location: prague},
+		WithOffset(CalendarResolution{Unit: Day, Count: 1, Location: prague}, 150*time.Minute),
+	}
+	for iter := 0; iter < 200; iter++ {
+		var (
+			// Around the start of the daylight saving time in Prague.
+			ts      = time.Date(2022, 3, 26, 0, 0, 0, 0, time.UTC).UnixMilli() + r.Int63n(48*time.Hour.Milliseconds())
+			samples []sample
+		)
+		for n := r.Intn(50) + 1; len(samples) < n; {
+			samples = append(samples, sample{t: ts, v: float64(r.Intn(100))})
+			// Often exactly at the window boundaries of the fixed resolutions.
+			ts += []int64{1, 333, 1000, time.Minute.Milliseconds(), r.Int63n(time.Hour.Milliseconds()) + 1}[r.Intn(5)]
+		}
+		// Split the series into multiple reads.
+		split := r.Intn(len(samples) + 1)
+		ls := labels.FromStrings("instance", "a")
+		set := series.NewListSet(newTestSeries(ls, samples[:split]...), newTestSeries(ls, samples[split:]...))
+
+		res := resolutions[r.Intn(len(resolutions))]
+		df, err := FromSeries(set, res, enableAllAggrs)
+		testutil.Ok(t, err)
+
+		var (
+			count   uint64
+			lastEnd time.Time
+		)
+		for i := Rows(df); i.Next(); {
+			row := i.At()
+			start, end := row[1].(time.Time), row[2].(time.Time)
+			testutil.Assert(t, !start.Before(lastEnd), "%s: window %v overlaps with the previous one ending at %v", res, start, lastEnd)
+			testutil.Equals(t, res.WindowEnd(start), end)
+			lastEnd = end
+
+			var want uint64
+			for _, s := range samples {
+				if st := time.UnixMilli(s.t); !st.Before(start) && st.Before(end) {
+					want++
+				}
+			}
+			testutil.Equals(t, want, row[5], "%s: samples in window %v-%v", res, start, end)
+			count += row[5].(uint64)
+		}
+		testutil.Equals(t, uint64(len(samples)), count, "%s: all samples aggregated once", res)
+	}
+}
+
+func TestFromSeries_QualityColumns(t *testing.T) {
+	df, err := FromSeries(series.NewListSet(
+		// Scraped every 15s, with scrapes missing in 00:01:00-00:02:00 window and target down until 00:03:15.
+		newTestSeries(labels.FromStrings("instance", "a"),
+			sample{t: 0, v: 1}, sample{t: 15000, v: 1}, sample{t: 30000, v: 1}, sample{t: 45000, v: 1},
+			sample{t: 60000, v: 1}, sample{t: 75000, v: 1},
+			sample{t: 195000, v: 1}, sample{t: 210000, v: 1},
+		),
+		// Interval is not known from a single sample.
+		newTestSeries(labels.FromStrings("instance", "b"), sample{t: 30000, v: 1}),
+		// Jittered 10s scrapes with an extra sample close to another one and a duplicated sample. The interval
+		// inferred from the later samples is used also for the first window.
+		newTestSeries(labels.FromStrings("instance", "c"),
+			sample{t: 50000, v: 1},
+			sample{t: 60000, v: 1}, sample{t: 70200, v: 1}, sample{t: 79900, v: 1}, sample{t: 80300, v: 1},
+			sample{t: 90000, v: 1}, sample{t: 90000, v: 1}, sample{t: 100100, v: 1}, sample{t: 110000, v: 1},
+			sample{t: 120000, v: 1},
+		),
+	), FixedResolution(time.Minute), func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.ExpectedCount.Enabled = true
+		o.MaxGap.Enabled = true
+		o.Completeness.Enabled = true
+		// Empty windows up to 00:03:00, after the last samples of "b" and "c".
+		o.EmptyWindows = EmptyWindowsOption{Mode: EmptyWindowsNull, MaxTime: time.Unix(180, 0)}
+	})
+	testutil.Ok(t, err)
+
+	var got [][]interface{}
+	for i := Rows(df); i.Next(); {
+		r := i.At()
+		got = append(got, append([]interface{}{r[0], r[1].(time.Time).Format("15:04:05")}, r[5:]...))
+	}
+	testutil.Equals(t, [][]interface{}{
+		{"a", "00:00:00", uint64(4), uint64(4), 15.0, 1.0},
+		{"a", "00:01:00", uint64(2), uint64(4), 15.0, 0.5},
+		{"a", "00:02:00", uint64(0), uint64(4), nil, 0.0},
+		// Samples are expected only up to the last sample, the scrape at 00:03:00 is missing.
+		{"a", "00:03:00", uint64(2), uint64(3), 120.0, 2.0 / 3},
+		{"b", "00:00:00", uint64(1), nil, nil, nil},
+		{"b", "00:01:00", uint64(0), nil, nil, nil},
+		{"b", "00:02:00", uint64(0), nil, nil, nil},
+		{"b", "00:03:00", uint64(0), nil, nil, nil},
+		// Samples are expected only since the first sample and up to the last sample of the series.
+		{"c", "00:00:00", uint64(1), uint64(1), nil, 1.0},
+		{"c", "00:01:00", uint64(8), uint64(6), 10.2, 1.0},
+		{"c", "00:02:00", uint64(1), uint64(1), 10.0, 1.0},
+		{"c", "00:03:00", uint64(0), uint64(0), nil, nil},
+	}, got)
+}
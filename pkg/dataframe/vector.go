@@ -0,0 +1,129 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"time"
+
+	"github.com/efficientgo/core/errors"
+)
+
+// Bitmap is a set of positions, e.g. of the null values of a vector.
+type Bitmap []uint64
+
+// Get returns true if the position is set.
+func (b Bitmap) Get(i int) bool {
+	w := i / 64
+	return w < len(b) && b[w]&(1<<(uint(i)%64)) != 0
+}
+
+// Set sets the position, growing the bitmap if needed.
+func (b *Bitmap) Set(i int) {
+	for len(*b) <= i/64 {
+		*b = append(*b, 0)
+	}
+	(*b)[i/64] |= 1 << (uint(i) % 64)
+}
+
+// ColumnVector is a column of a batch. It is one of StringVector, FloatVector, UintVector or TimeVector, depending on
+// the type of the column.
+type ColumnVector interface {
+	Len() int
+	IsNull(i int) bool
+	// Value returns the i-th value boxed, nil if it is null. Prefer the typed values of the vector.
+	Value(i int) interface{}
+
+	appendValue(v interface{}) error
+}
+
+// Vector stores the values of a column. Null values are marked in Nulls and have the zero value in Values.
+type Vector[T any] struct {
+	Values []T
+	// Nulls is nil if there are no null values.
+	Nulls Bitmap
+}
+
+type (
+	StringVector = Vector[string]
+	FloatVector  = Vector[float64]
+	UintVector   = Vector[uint64]
+	TimeVector   = Vector[time.Time]
+)
+
+// NewVector returns an empty vector for the column type.
+func NewVector(t Type) (ColumnVector, error) {
+	switch t {
+	case TypeString:
+		return &StringVector{}, nil
+	case TypeFloat:
+		return &FloatVector{}, nil
+	case TypeUint:
+		return &UintVector{}, nil
+	case TypeTime:
+		return &TimeVector{}, nil
+	default:
+		return nil, errors.Newf("unknown column type %q", t)
+	}
+}
+
+func (v *Vector[T]) Len() int { return len(v.Values) }
+
+func (v *Vector[T]) IsNull(i int) bool { return v.Nulls.Get(i) }
+
+func (v *Vector[T]) Value(i int) interface{} {
+	if v.Nulls.Get(i) {
+		return nil
+	}
+	return v.Values[i]
+}
+
+// Append appends the value.
+func (v *Vector[T]) Append(x T) { v.Values = append(v.Values, x) }
+
+// AppendNull appends null.
+func (v *Vector[T]) AppendNull() {
+	var zero T
+	v.Nulls.Set(len(v.Values))
+	v.Values = append(v.Values, zero)
+}
+
+func (v *Vector[T]) appendValue(x interface{}) error {
+	if x == nil {
+		v.AppendNull()
+		return nil
+	}
+	t, ok := x.(T)
+	if !ok {
+		return errors.Newf("value %v of type %T does not match the column type %T", x, x, t)
+	}
+	v.Append(t)
+	return nil
+}
+
+// Batch is a part of the rows of the dataframe, stored column by column in the order of the schema.
+// All columns have the same length.
+type Batch struct {
+	Columns []ColumnVector
+}
+
+// Len returns the number of rows of the batch.
+func (b Batch) Len() int {
+	if len(b.Columns) == 0 {
+		return 0
+	}
+	return b.Columns[0].Len()
+}
+
+// newBatch returns an empty batch for the schema.
+func newBatch(schema Schema) (Batch, error) {
+	b := Batch{Columns: make([]ColumnVector, 0, len(schema))}
+	for _, c := range schema {
+		v, err := NewVector(c.Type)
+		if err != nil {
+			return Batch{}, errors.Wrapf(err, "column %s", c.Name)
+		}
+		b.Columns = append(b.Columns, v)
+	}
+	return b, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package dataframe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func TestVector(t *testing.T) {
+	v := &FloatVector{}
+	for i := 0; i < 130; i++ {
+		if i%65 == 0 {
+			v.AppendNull()
+			continue
+		}
+		v.Append(float64(i))
+	}
+	testutil.Equals(t, 130, v.Len())
+	testutil.Equals(t, Bitmap{1, 0b10}, v.Nulls)
+	testutil.Assert(t, v.IsNull(0) && v.IsNull(65) && !v.IsNull(64) && !v.IsNull(200))
+	testutil.Equals(t, nil, v.Value(65))
+	testutil.Equals(t, 66.0, v.Value(66))
+}
+
+func TestFromRows(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	schema := Schema{
+		{Name: "job", Type: TypeString},
+		{Name: "_sample_start", Type: TypeTime},
+		{Name: "_count", Type: TypeUint},
+		{Name: "_sum", Type: TypeFloat},
+	}
+	rows := []Row{
+		{"a", start, uint64(2), 1.5},
+		{nil, start.Add(time.Minute), uint64(0), nil},
+	}
+	df := FromRows(schema, rows)
+
+	batches := df.Batches()
+	testutil.Assert(t, batches.Next())
+	b := batches.At()
+	testutil.Equals(t, 2, b.Len())
+	testutil.Equals(t, &StringVector{Values: []string{"a", ""}, Nulls: Bitmap{0b10}}, b.Columns[0])
+	testutil.Equals(t, &UintVector{Values: []uint64{2, 0}}, b.Columns[2])
+	testutil.Assert(t, !batches.Next())
+
+	var got []Row
+	for i := Rows(df); i.Next(); {
+		got = append(got, i.At())
+	}
+	testutil.Equals(t, rows, got)
+
+	// Batches of concatenated dataframes follow each other, empty dataframes have none.
+	df, err := Concat(df, FromRows(schema, nil), df)
+	testutil.Ok(t, err)
+	var n int
+	for i := df.Batches(); i.Next(); {
+		n += i.At().Len()
+	}
+	testutil.Equals(t, 4, n)
+
+	// Values not matching the column type are null, unless rejected.
+	got = nil
+	for i := Rows(FromRows(schema, []Row{{"a", start, 2, 1.5}})); i.Next(); {
+		got = append(got, i.At())
+	}
+	testutil.Equals(t, []Row{{"a", start, nil, 1.5}}, got)
+	_, err = FromRowsE(schema, []Row{{"a", start, 2, 1.5}})
+	testutil.NotOk(t, err)
+}
+
+func TestFromSeries_Batches(t *testing.T) {
+	df, err := FromSeries(series.NewListSet(
+		newTestSeries(labels.FromStrings("__name__", "up", "instance", "a"), sample{t: 10000, v: 1}),
+		newTestSeries(labels.FromStrings("__name__", "up", "job", "b"), sample{t: 70000, v: 2}),
+	), FixedResolution(time.Minute), func(o *AggrsOptions) {
+		o.Count.Enabled = true
+		o.Sum.Enabled = true
+	})
+	testutil.Ok(t, err)
+
+	batches := df.Batches()
+	testutil.Assert(t, batches.Next())
+	b := batches.At()
+	testutil.Equals(t, len(df.Schema()), len(b.Columns))
+	// Labels missing in some series are null.
+	testutil.Equals(t, &StringVector{Values: []string{"a", ""}, Nulls: Bitmap{0b10}}, b.Columns[0])
+	testutil.Equals(t, &StringVector{Values: []string{"", "b"}, Nulls: Bitmap{0b01}}, b.Columns[1])
+	testutil.Equals(t, []uint64{1, 1}, b.Columns[6].(*UintVector).Values)
+	testutil.Equals(t, []float64{1, 2}, b.Columns[7].(*FloatVector).Values)
+	testutil.Assert(t, !batches.Next())
+
+	t.Run("bounded batches", func(t *testing.T) {
+		defer func(n int) { maxBatchRows = n }(maxBatchRows)
+		maxBatchRows = 2
+
+		var samples []sample
+		for ts := int64(10000); ts < 300000; ts += 60000 {
+			samples = append(samples, sample{t: ts, v: 1})
+		}
+		df, err := FromSeries(series.NewListSet(newTestSeries(labels.FromStrings("__name__", "up"), samples...)), FixedResolution(time.Minute))
+		testutil.Ok(t, err)
+
+		var lens []int
+		var starts []time.Time
+		for i := df.Batches(); i.Next(); {
+			b := i.At()
+			lens = append(lens, b.Len())
+			starts = append(starts, b.Columns[0].(*TimeVector).Values...)
+		}
+		testutil.Equals(t, []int{2, 2, 1}, lens)
+		testutil.Equals(t, 5, len(starts))
+		for i, s := range starts {
+			testutil.Equals(t, time.Unix(int64(60*i), 0).UTC(), s.UTC())
+		}
+	})
+}
@@ -4,18 +4,31 @@
 package exporter
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/efficientgo/core/errors"
+	"github.com/efficientgo/core/merrors"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/client"
 
 	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/version"
 )
 
 type Type string
 
+// cleanupTimeout bounds the removal of objects left by a failed export. The export context might be already canceled.
+const cleanupTimeout = time.Minute
+
 const (
 	PARQUET Type = "PARQUET"
 )
@@ -33,11 +46,53 @@ type Encoder interface {
 	Encode(io.Writer, dataframe.Dataframe) (err error)
 }
 
+// A Decoder reads the dataframe serialized by the Encoder of the same type.
+type Decoder interface {
+	Decode(io.Reader) (dataframe.Dataframe, error)
+}
+
+// Summary describes the exported object.
+type Summary struct {
+	Schema dataframe.Schema
+	Rows   int64
+	// MinTime and MaxTime are the time range of all time columns, zero if there are no time values.
+	MinTime, MaxTime time.Time
+	// Head has the first rows of the object.
+	Head dataframe.Dataframe
+}
+
+// A Summarizer reads the summary of the object stored in the bucket, without reading all its rows.
+type Summarizer interface {
+	Summarize(ctx context.Context, bkt objstore.BucketReader, name string, limit int) (Summary, error)
+}
+
+// Read downloads and decodes the dataframe stored under the given path.
+func Read(ctx context.Context, bkt objstore.BucketReader, path string, dec Decoder) (_ dataframe.Dataframe, err error) {
+	r, err := bkt.Get(ctx, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", path)
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	df, err := dec.Decode(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %s", path)
+	}
+	return df, nil
+}
+
 type Exporter struct {
 	enc Encoder
 
 	path string
 	bkt  objstore.Bucket
+
+	metrics  *Metrics
+	manifest Manifest
 }
 
 func New(c Encoder, path string, bkt objstore.Bucket) *Exporter {
@@ -48,19 +103,163 @@ func New(c Encoder, path string, bkt objstore.Bucket) *Exporter {
 	}
 }
 
-// Export encodes and streams the dataframe to given bucket. On error partial result might occur.
-// It's caller responsibility to clean after error.
+// WithPath returns a copy of the exporter that stores the dataframe under the given path.
+func (e *Exporter) WithPath(path string) *Exporter {
+	c := *e
+	c.path = path
+	return &c
+}
+
+// WithMetrics returns a copy of the exporter instrumented with the given metrics.
+func (e *Exporter) WithMetrics(m *Metrics) *Exporter {
+	c := *e
+	c.metrics = m
+	return &c
+}
+
+// WithManifest returns a copy of the exporter describing the exported dataframe with the given manifest. The version,
+// schema, number of rows, size and checksum are filled in by the exporter.
+func (e *Exporter) WithManifest(m Manifest) *Exporter {
+	c := *e
+	c.manifest = m
+	return &c
+}
+
+// Manifest returns the manifest describing the exported dataframe.
+func (e *Exporter) Manifest() Manifest {
+	return e.manifest
+}
+
+// Path returns the object path the dataframe is stored under.
+func (e *Exporter) Path() string {
+	return e.path
+}
+
+// Bucket returns the bucket the dataframe is stored in.
+func (e *Exporter) Bucket() objstore.Bucket {
+	return e.bkt
+}
+
+// PathWithSuffix returns the object path with the suffix added just before the extension,
+// e.g. "dir/out.parquet" with "up" suffix becomes "dir/out-up.parquet".
+func PathWithSuffix(p, suffix string) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "-" + suffix + ext
+}
+
+// SuccessMarkerPath returns the path of the marker object uploaded once the dataframe is fully published under
+// the given path, e.g. "dir/out.parquet" has "dir/_out.parquet.SUCCESS" marker. The marker is prefixed with "_",
+// so it is ignored by query engines reading all objects of the directory.
+func SuccessMarkerPath(p string) string {
+	return path.Join(path.Dir(p), "_"+path.Base(p)+".SUCCESS")
+}
+
+// tmpPath returns unique path of the temporary object the dataframe is uploaded to before being renamed to
+// the given path. The temporary object is prefixed with ".", so it is ignored by query engines.
+// NOTE: The manifest of the object (see ManifestPath) shares the prefix of the object.
+func tmpPath(p string) string {
+	return path.Join(path.Dir(p), fmt.Sprintf(".%s.tmp-%d", path.Base(p), time.Now().UnixNano()))
+}
+
+// Renamer is implemented by buckets without atomic uploads, able to move the object under another name in place,
+// e.g. a local filesystem.
+type Renamer interface {
+	Rename(ctx context.Context, from, to string) error
+}
+
+// Export encodes the dataframe and uploads it into the bucket, then uploads its manifest (see ManifestPath) and
+// the success marker (see SuccessMarkerPath) next to it. Consumers should wait for the marker before reading
+// the object. Exporting into the same path again replaces the previous object, its manifest and marker are removed
+// once the new object is published.
+// Object storages upload objects atomically, so the dataframe is uploaded under the final path directly. Buckets
+// implementing Renamer get the dataframe uploaded into a temporary object first, renamed once complete.
+// On error, only the temporary object is removed, objects of the previous export are left untouched until the new
+// object replaces them.
 func (e *Exporter) Export(ctx context.Context, df dataframe.Dataframe) (err error) {
+	name := e.path
+	rn, rename := e.bkt.(Renamer)
+	if rename {
+		name = tmpPath(e.path)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+			defer cancel()
+
+			if derr := e.delete(ctx, name); derr != nil {
+				err = merrors.New(err, errors.Wrapf(derr, "delete temporary object %s", name)).Err()
+			}
+		}()
+	}
+
+	m, err := e.encodeAndUpload(ctx, name, df)
+	if err != nil {
+		return err
+	}
+	if rename {
+		if err := rn.Rename(ctx, name, e.path); err != nil {
+			e.observeFailure(StageUpload)
+			return errors.Wrapf(err, "rename %s", name)
+		}
+	}
+
+	marker, manifest := SuccessMarkerPath(e.path), ManifestPath(e.path)
+	// The marker and manifest of the previous export into the same path no longer match the object.
+	for _, name := range []string{marker, manifest} {
+		if err := e.delete(ctx, name); err != nil {
+			e.observeFailure(StageUpload)
+			return errors.Wrapf(err, "delete previous %s", name)
+		}
+	}
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal manifest")
+	}
+	if err := e.bkt.Upload(ctx, manifest, bytes.NewReader(b)); err != nil {
+		e.observeFailure(StageUpload)
+		return errors.Wrap(err, "upload manifest")
+	}
+	if err := e.bkt.Upload(ctx, marker, bytes.NewReader(nil)); err != nil {
+		e.observeFailure(StageUpload)
+		return errors.Wrap(err, "upload success marker")
+	}
+	return nil
+}
+
+// encodeAndUpload encodes and streams the dataframe into the given object. Returns the manifest of the object.
+func (e *Exporter) encodeAndUpload(ctx context.Context, name string, df dataframe.Dataframe) (m Manifest, err error) {
+	m = e.manifest
+	if m.Version == "" {
+		m.Version = version.Version
+	}
+	m.Schema = manifestSchema(df.Schema())
+
 	r, w := io.Pipe()
+	hash := sha256.New()
+	var out io.Writer = sizeWriter{Writer: io.MultiWriter(w, hash), size: &m.Size}
+	var rows int64
+	df = rowsCountingDataframe{Dataframe: df, rows: &rows}
+	if e.metrics != nil {
+		df = countingDataframe{Dataframe: df, rows: e.metrics.Rows}
+		out = countingWriter{Writer: out, bytes: e.metrics.UploadedBytes}
+	}
 
 	errch := make(chan error, 1)
 	go func() {
-		// TODO(bwplotka): Log error from close (e.g using runutil.Close... package).
-		defer w.Close()
-		if err := e.enc.Encode(w, df); err != nil {
-			errch <- errors.Wrap(err, "encode")
+		start := time.Now()
+		encode := e.enc.Encode
+		if enc, ok := e.enc.(ManifestEncoder); ok {
+			encode = func(w io.Writer, df dataframe.Dataframe) error { return enc.EncodeWithManifest(w, df, m) }
+		}
+		if err := encode(out, df); err != nil {
+			e.observeFailure(StageEncode)
+			err = errors.Wrap(err, "encode")
+			// Fail the upload, so the partially encoded object is not stored.
+			_ = w.CloseWithError(err)
+			errch <- err
 			return
 		}
+		// TODO(bwplotka): Log error from close (e.g using runutil.Close... package).
+		_ = w.Close()
+		e.observeDuration(StageEncode, start)
 		errch <- nil
 	}()
 	defer func() {
@@ -69,10 +268,35 @@ func (e *Exporter) Export(ctx context.Context, df dataframe.Dataframe) (err erro
 		if cerr := <-errch; cerr != nil && err == nil {
 			err = cerr
 		}
+		m.Rows = rows
+		m.SHA256 = hex.EncodeToString(hash.Sum(nil))
 	}()
 
-	if err := e.bkt.Upload(ctx, e.path, r); err != nil {
-		return errors.Wrap(err, "upload")
+	start := time.Now()
+	if err := e.bkt.Upload(ctx, name, r); err != nil {
+		e.observeFailure(StageUpload)
+		return m, errors.Wrap(err, "upload")
+	}
+	e.observeDuration(StageUpload, start)
+	return m, nil
+}
+
+// delete removes the object, if it exists.
+func (e *Exporter) delete(ctx context.Context, name string) error {
+	if err := e.bkt.Delete(ctx, name); err != nil && !e.bkt.IsObjNotFoundErr(err) {
+		return err
 	}
 	return nil
 }
+
+func (e *Exporter) observeDuration(stage string, start time.Time) {
+	if e.metrics != nil {
+		e.metrics.StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (e *Exporter) observeFailure(stage string) {
+	if e.metrics != nil {
+		e.metrics.StageFailures.WithLabelValues(stage).Inc()
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package exporter
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/version"
+)
+
+// testEncoder iterates through all rows and writes given content, failing after it if err is set.
+type testEncoder struct {
+	content string
+	err     error
+}
+
+func (e testEncoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	for i := df.Batches(); i.Next(); {
+	}
+	if _, err := w.Write([]byte(e.content)); err != nil {
+		return err
+	}
+	return e.err
+}
+
+// failingBucket fails uploads of the given object.
+type failingBucket struct {
+	objstore.Bucket
+	name string
+}
+
+func (b failingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if name == b.name {
+		return errors.New("upload failed")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+// renamingBucket records renamed objects.
+type renamingBucket struct {
+	objstore.Bucket
+	renamed []string
+}
+
+func (b *renamingBucket) Rename(ctx context.Context, from, to string) error {
+	r, err := b.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := b.Upload(ctx, to, r); err != nil {
+		return err
+	}
+	b.renamed = append(b.renamed, to)
+	return b.Delete(ctx, from)
+}
+
+func objects(t *testing.T, bkt objstore.Bucket) map[string]string {
+	t.Helper()
+
+	ret := map[string]string{}
+	testutil.Ok(t, bkt.Iter(context.Background(), "dir/", func(name string) error {
+		r, err := bkt.Get(context.Background(), name)
+		testutil.Ok(t, err)
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		testutil.Ok(t, err)
+		ret[name] = string(b)
+		return nil
+	}))
+	return ret
+}
+
+func TestExporter_Export(t *testing.T) {
+	ctx := context.Background()
+	df := dataframe.FromRows(nil, nil)
+
+	bkt := objstore.NewInMemBucket()
+	testutil.Ok(t, New(testEncoder{content: "first"}, "dir/out.parquet", bkt).Export(ctx, df))
+	objs := objects(t, bkt)
+	testutil.Equals(t, 3, len(objs))
+	testutil.Equals(t, "first", objs["dir/out.parquet"])
+	testutil.Equals(t, "", objs["dir/_out.parquet.SUCCESS"])
+
+	// Exporting again replaces the object and its manifest.
+	m := Manifest{Query: "up", Resolution: "5m", Series: 3}
+	schema := dataframe.Schema{{Name: "job", Type: dataframe.TypeString}}
+	df = dataframe.FromRows(schema, []dataframe.Row{{"a"}, {"b"}})
+	testutil.Ok(t, New(testEncoder{content: "second"}, "dir/out.parquet", bkt).WithManifest(m).Export(ctx, df))
+	objs = objects(t, bkt)
+	testutil.Equals(t, 3, len(objs))
+	testutil.Equals(t, "second", objs["dir/out.parquet"])
+
+	got, err := ReadManifest(ctx, bkt, "dir/out.parquet")
+	testutil.Ok(t, err)
+	m.Version = version.Version
+	m.Rows = 2
+	m.Schema = []ManifestColumn{{Name: "job", Type: dataframe.TypeString}}
+	m.Size = 6
+	// Checksum of "second".
+	m.SHA256 = "16367aacb67a4a017c8da8ab95682ccb390863780f7114dda0a0e0c55644c7c4"
+	testutil.Equals(t, m, *got)
+
+	t.Run("rename", func(t *testing.T) {
+		bkt := &renamingBucket{Bucket: objstore.NewInMemBucket()}
+		testutil.Ok(t, New(testEncoder{content: "first"}, "dir/out.parquet", bkt).Export(ctx, df))
+		testutil.Equals(t, []string{"dir/out.parquet"}, bkt.renamed)
+		objs := objects(t, bkt)
+		testutil.Equals(t, 3, len(objs))
+		testutil.Equals(t, "first", objs["dir/out.parquet"])
+	})
+	t.Run("encode failure", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		err := New(testEncoder{content: "partial", err: errors.New("encode failed")}, "dir/out.parquet", bkt).Export(ctx, df)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, map[string]string{}, objects(t, bkt))
+	})
+	t.Run("upload failure", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, New(testEncoder{content: "first"}, "dir/out.parquet", bkt).Export(ctx, df))
+		before := objects(t, bkt)
+
+		// The previous export is left untouched.
+		err := New(testEncoder{content: "second"}, "dir/out.parquet", failingBucket{Bucket: bkt, name: "dir/out.parquet"}).Export(ctx, df)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, before, objects(t, bkt))
+	})
+	t.Run("marker failure", func(t *testing.T) {
+		bkt := objstore.NewInMemBucket()
+		testutil.Ok(t, New(testEncoder{content: "first"}, "dir/out.parquet", bkt).Export(ctx, df))
+
+		err := New(testEncoder{content: "second"}, "dir/out.parquet", failingBucket{Bucket: bkt, name: "dir/_out.parquet.SUCCESS"}).Export(ctx, df)
+		testutil.NotOk(t, err)
+		// The object is replaced, but the marker of the previous export is not left, as it would not match.
+		objs := objects(t, bkt)
+		testutil.Equals(t, 2, len(objs))
+		testutil.Equals(t, "second", objs["dir/out.parquet"])
+		_, ok := objs["dir/_out.parquet.SUCCESS"]
+		testutil.Assert(t, !ok, "marker of the previous export left")
+	})
+}
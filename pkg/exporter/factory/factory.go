@@ -4,12 +4,17 @@
 package factory
 
 import (
+	"context"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/efficientgo/core/errors"
 	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/providers/filesystem"
 	"gopkg.in/yaml.v2"
 
 	"github.com/thanos-community/obslytics/pkg/exporter"
@@ -27,6 +32,17 @@ func NewExporter(logger log.Logger, cfg exporter.Config) (*exporter.Exporter, er
 	if err != nil {
 		return nil, errors.Wrap(err, "creating storage")
 	}
+	if strings.ToUpper(string(cfg.Storage.Type)) == string(client.FILESYSTEM) {
+		fsConf, err := yaml.Marshal(cfg.Storage.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "filesystem storage configuration")
+		}
+		c := filesystem.Config{}
+		if err := yaml.Unmarshal(fsConf, &c); err != nil {
+			return nil, errors.Wrap(err, "parsing filesystem storage configuration")
+		}
+		bkt = filesystemBucket{InstrumentedBucket: bkt, dir: c.Directory}
+	}
 
 	var e exporter.Encoder
 	switch exporter.Type(strings.ToUpper(string(cfg.Type))) {
@@ -38,3 +54,27 @@ func NewExporter(logger log.Logger, cfg exporter.Config) (*exporter.Exporter, er
 
 	return exporter.New(e, cfg.Path, bkt), nil
 }
+
+// filesystemBucket publishes exported objects atomically by renaming them, see exporter.Renamer.
+type filesystemBucket struct {
+	objstore.InstrumentedBucket
+	dir string
+}
+
+func (b filesystemBucket) Rename(_ context.Context, from, to string) error {
+	to = filepath.Join(b.dir, filepath.FromSlash(to))
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(b.dir, filepath.FromSlash(from)), to)
+}
+
+// NewDecoder returns decoder of the given export type.
+func NewDecoder(t exporter.Type) (exporter.Decoder, error) {
+	switch exporter.Type(strings.ToUpper(string(t))) {
+	case exporter.PARQUET:
+		return parquet.NewDecoder(), nil
+	default:
+		return nil, errors.Newf("unsupported export type %v", t)
+	}
+}
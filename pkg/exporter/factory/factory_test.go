@@ -0,0 +1,75 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package factory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore/client"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+)
+
+func TestNewExporter_Filesystem(t *testing.T) {
+	dir := t.TempDir()
+	e, err := NewExporter(log.NewNopLogger(), exporter.Config{
+		Type: exporter.PARQUET,
+		Path: "dir/out.parquet",
+		Storage: client.BucketConfig{
+			Type:   client.FILESYSTEM,
+			Config: map[string]interface{}{"directory": dir},
+		},
+	})
+	testutil.Ok(t, err)
+	_, ok := e.Bucket().(exporter.Renamer)
+	testutil.Assert(t, ok, "filesystem bucket does not rename objects")
+
+	schema := dataframe.Schema{
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "_sample_start", Type: dataframe.TypeTime, Required: true},
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	df := dataframe.FromRows(schema, []dataframe.Row{{"a", start}, {"b", start}})
+	testutil.Ok(t, e.Export(context.Background(), df))
+
+	// The temporary object is renamed into the nested directory, nothing else is left behind.
+	testutil.Equals(t, []string{"dir/_out.parquet.SUCCESS", "dir/out.parquet", "dir/out.parquet.manifest.json"}, files(t, dir))
+
+	dec, err := NewDecoder(exporter.PARQUET)
+	testutil.Ok(t, err)
+	got, err := exporter.Read(context.Background(), e.Bucket(), e.Path(), dec)
+	testutil.Ok(t, err)
+	testutil.Equals(t, dataframe.ToString(df), dataframe.ToString(got))
+
+	// The temporary object is removed when it can't be renamed, e.g. over a non-empty directory.
+	e = e.WithPath("other/out.parquet")
+	testutil.Ok(t, os.MkdirAll(filepath.Join(dir, "other", "out.parquet", "x"), os.ModePerm))
+	testutil.NotOk(t, e.Export(context.Background(), df))
+	testutil.Equals(t, []string{"dir/_out.parquet.SUCCESS", "dir/out.parquet", "dir/out.parquet.manifest.json"}, files(t, dir))
+}
+
+// files returns the paths of all files in the directory, relative to it.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var ret []string
+	testutil.Ok(t, filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		ret = append(ret, filepath.ToSlash(rel))
+		return err
+	}))
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,133 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/thanos-io/objstore"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+)
+
+// ManifestKey is the key of the manifest in the key-value metadata of the exported object.
+const ManifestKey = "obslytics.manifest"
+
+// Manifest describes the exported object and how it was produced. It is uploaded next to the object
+// (see ManifestPath) and embedded in the object itself by encoders implementing ManifestEncoder.
+type Manifest struct {
+	// Version of obslytics that exported the object.
+	Version string        `json:"version"`
+	Input   ManifestInput `json:"input"`
+	// Matchers or Query selecting the exported series.
+	Matchers []string `json:"matchers,omitempty"`
+	Query    string   `json:"query,omitempty"`
+	// MinTime and MaxTime is the exported time range, inclusive on both ends.
+	MinTime    time.Time `json:"min_time"`
+	MaxTime    time.Time `json:"max_time"`
+	Resolution string    `json:"resolution"`
+	// Timezone the calendar resolution is aligned in.
+	Timezone     string   `json:"timezone,omitempty"`
+	Aggregations []string `json:"aggregations"`
+
+	Series int              `json:"series"`
+	Rows   int64            `json:"rows"`
+	Schema []ManifestColumn `json:"schema"`
+
+	// Size and SHA256 checksum of the exported object. Not set in the manifest embedded in the object itself.
+	Size   int64  `json:"size_bytes,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ManifestInput describes the input the series were read from.
+type ManifestInput struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// ManifestColumn describes a single column of the exported dataframe.
+type ManifestColumn struct {
+	Name     string         `json:"name"`
+	Type     dataframe.Type `json:"type"`
+	Required bool           `json:"required,omitempty"`
+}
+
+// ManifestEncoder is implemented by encoders able to embed the manifest in the encoded output.
+type ManifestEncoder interface {
+	// EncodeWithManifest encodes the dataframe the same way as Encode does, with the manifest embedded under
+	// ManifestKey. The encoder sets the number of rows of the manifest, as it is not known upfront.
+	EncodeWithManifest(io.Writer, dataframe.Dataframe, Manifest) error
+}
+
+// ManifestPath returns the path of the manifest of the object with the given path, e.g. "dir/out.parquet" has
+// "dir/out.parquet.manifest.json" manifest.
+func ManifestPath(p string) string {
+	return p + ".manifest.json"
+}
+
+// ReadManifest downloads the manifest of the object with the given path. Returns nil manifest if it does not exist.
+func ReadManifest(ctx context.Context, bkt objstore.BucketReader, p string) (*Manifest, error) {
+	name := ManifestPath(p)
+	r, err := bkt.Get(ctx, name)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get manifest %s", name)
+	}
+	defer r.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, errors.Wrapf(err, "decode manifest %s", name)
+	}
+	return m, nil
+}
+
+func manifestSchema(s dataframe.Schema) []ManifestColumn {
+	ret := make([]ManifestColumn, 0, len(s))
+	for _, c := range s {
+		ret = append(ret, ManifestColumn{Name: c.Name, Type: c.Type, Required: c.Required})
+	}
+	return ret
+}
+
+// rowsCountingDataframe counts the rows of the batches iterated over.
+type rowsCountingDataframe struct {
+	dataframe.Dataframe
+	rows *int64
+}
+
+func (df rowsCountingDataframe) Batches() dataframe.BatchIterator {
+	return &rowsCountingIterator{BatchIterator: df.Dataframe.Batches(), rows: df.rows}
+}
+
+type rowsCountingIterator struct {
+	dataframe.BatchIterator
+	rows *int64
+}
+
+func (i *rowsCountingIterator) Next() bool {
+	if !i.BatchIterator.Next() {
+		return false
+	}
+	*i.rows += int64(i.At().Len())
+	return true
+}
+
+// sizeWriter counts the bytes written.
+type sizeWriter struct {
+	io.Writer
+	size *int64
+}
+
+func (w sizeWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	*w.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,59 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package exporter
+
+import (
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+)
+
+const (
+	StageEncode = "encode"
+	StageUpload = "upload"
+)
+
+// Metrics instruments the export. StageDuration and StageFailures are partitioned by the "stage" label,
+// set to StageEncode or StageUpload by the exporter. Encoding and upload are streamed, so their durations overlap.
+type Metrics struct {
+	Rows          prometheus.Counter
+	UploadedBytes prometheus.Counter
+	StageDuration *prometheus.HistogramVec
+	StageFailures *prometheus.CounterVec
+}
+
+type countingRowsIterator struct {
+	dataframe.BatchIterator
+	rows prometheus.Counter
+}
+
+func (i *countingRowsIterator) Next() bool {
+	if !i.BatchIterator.Next() {
+		return false
+	}
+	i.rows.Add(float64(i.At().Len()))
+	return true
+}
+
+type countingDataframe struct {
+	dataframe.Dataframe
+	rows prometheus.Counter
+}
+
+func (df countingDataframe) Batches() dataframe.BatchIterator {
+	return &countingRowsIterator{BatchIterator: df.Dataframe.Batches(), rows: df.rows}
+}
+
+type countingWriter struct {
+	io.Writer
+	bytes prometheus.Counter
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.bytes.Add(float64(n))
+	return n, err
+}
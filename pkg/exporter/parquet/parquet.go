@@ -4,13 +4,19 @@
 package parquet
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"time"
 
 	"github.com/efficientgo/core/errors"
+	"github.com/thanos-io/objstore"
+	"github.com/xitongsys/parquet-go-source/buffer"
 	parquetwriter "github.com/xitongsys/parquet-go-source/writerfile"
 	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
 	"github.com/xitongsys/parquet-go/source"
 	"github.com/xitongsys/parquet-go/writer"
 
@@ -18,8 +24,13 @@ import (
 	"github.com/thanos-community/obslytics/pkg/exporter"
 )
 
-// Compile-time check if parquet Encoder implements exporter.Encoder interface.
-var _ exporter.Encoder = &Encoder{}
+// Compile-time check if parquet Encoder and Decoder implement exporter interfaces.
+var (
+	_ exporter.Encoder         = &Encoder{}
+	_ exporter.ManifestEncoder = &Encoder{}
+	_ exporter.Decoder         = &Decoder{}
+	_ exporter.Summarizer      = &Decoder{}
+)
 
 type Encoder struct{}
 
@@ -27,7 +38,16 @@ func NewEncoder() *Encoder {
 	return &Encoder{}
 }
 
-func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) (err error) {
+func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) error {
+	return e.encode(w, df, nil)
+}
+
+// EncodeWithManifest encodes the dataframe with the JSON encoded manifest stored in the key-value metadata of the file.
+func (e *Encoder) EncodeWithManifest(w io.Writer, df dataframe.Dataframe, m exporter.Manifest) error {
+	return e.encode(w, df, &m)
+}
+
+func (e *Encoder) encode(w io.Writer, df dataframe.Dataframe, m *exporter.Manifest) (err error) {
 	parqf := parquetwriter.NewWriterFile(w)
 	parqw, err := initCSVWriter(parqf, df)
 	if err != nil {
@@ -39,36 +59,78 @@ func (e *Encoder) Encode(w io.Writer, df dataframe.Dataframe) (err error) {
 		}
 	}()
 
-	i := df.RowsIterator()
-	s := df.Schema()
-	for i.Next() {
-		r := i.At()
-		d := make([]interface{}, 0, len(r))
-		for i, cell := range r {
-			c := s[i]
-			switch c.Type {
-			case dataframe.TypeString:
-				d = append(d, cell)
-			case dataframe.TypeFloat:
-				d = append(d, cell)
-			case dataframe.TypeUint:
-				v := cell.(uint64)
+	var rows int64
+	for i := df.Batches(); i.Next(); {
+		b := i.At()
+		n, width := b.Len(), len(b.Columns)
+		// The writer takes rows, transpose the columns of the batch into them. Null cells, e.g. label missing in some
+		// series or aggregation of empty window, are left nil.
+		cells := make([]interface{}, n*width)
+		for c, col := range b.Columns {
+			if df.Schema()[c].Required && hasNulls(col) {
+				return errors.Newf("null value in required column %s", df.Schema()[c].Name)
+			}
+			switch v := col.(type) {
+			case *dataframe.StringVector:
+				// Values of labels repeat in consecutive rows, box them once.
+				var prev interface{}
+				transpose(cells, c, width, v, func(x string) interface{} {
+					if p, ok := prev.(string); !ok || p != x {
+						prev = x
+					}
+					return prev
+				})
+			case *dataframe.FloatVector:
+				transpose(cells, c, width, v, func(x float64) interface{} { return x })
+			case *dataframe.UintVector:
 				// There has been some issue with uint and parquet-go, typecasting to int64 instead.
-				d = append(d, int64(v))
-			case dataframe.TypeTime:
-				v := cell.(time.Time)
-				d = append(d, v.Unix()*1000)
+				transpose(cells, c, width, v, func(x uint64) interface{} { return int64(x) })
+			case *dataframe.TimeVector:
+				transpose(cells, c, width, v, func(x time.Time) interface{} { return x.UnixMilli() })
 			default:
-				d = append(d, cell)
+				return errors.Newf("unsupported column vector %T of column %s", col, df.Schema()[c].Name)
 			}
 		}
-		if err := parqw.Write(d); err != nil {
-			return errors.Wrap(err, "writing a row")
+		for r := 0; r < n; r++ {
+			if err := parqw.Write(cells[r*width : (r+1)*width : (r+1)*width]); err != nil {
+				return errors.Wrap(err, "writing a row")
+			}
 		}
+		rows += int64(n)
+	}
+
+	if m != nil {
+		// The footer with the metadata is written once the writer is stopped.
+		m.Rows = rows
+		b, err := json.Marshal(m)
+		if err != nil {
+			return errors.Wrap(err, "marshal manifest")
+		}
+		v := string(b)
+		parqw.Footer.KeyValueMetadata = append(parqw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: exporter.ManifestKey, Value: &v})
 	}
 	return nil
 }
 
+// hasNulls returns true if any value of the vector is null.
+func hasNulls(v dataframe.ColumnVector) bool {
+	for i := 0; i < v.Len(); i++ {
+		if v.IsNull(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// transpose sets the c-th cell of every row of the given width to the converted value of the vector, unless null.
+func transpose[T any](cells []interface{}, c, width int, v *dataframe.Vector[T], convert func(T) interface{}) {
+	for r, x := range v.Values {
+		if !v.IsNull(r) {
+			cells[r*width+c] = convert(x)
+		}
+	}
+}
+
 func initCSVWriter(parqf source.ParquetFile, df dataframe.Dataframe) (*writer.CSVWriter, error) {
 	schema := df.Schema()
 	pqSchema := make([]string, 0, len(schema))
@@ -84,7 +146,11 @@ func initCSVWriter(parqf source.ParquetFile, df dataframe.Dataframe) (*writer.CS
 		case dataframe.TypeTime:
 			pqType = "INT64, convertedtype=TIMESTAMP_MILLIS"
 		}
-		pqSchema = append(pqSchema, fmt.Sprintf("name=%s, type=%s", c.Name, pqType))
+		repetition := "OPTIONAL"
+		if c.Required {
+			repetition = "REQUIRED"
+		}
+		pqSchema = append(pqSchema, fmt.Sprintf("name=%s, type=%s, repetitiontype=%s", c.Name, pqType, repetition))
 	}
 
 	parqw, err := writer.NewCSVWriter(pqSchema, parqf, 4)
@@ -95,3 +161,224 @@ func initCSVWriter(parqf source.ParquetFile, df dataframe.Dataframe) (*writer.CS
 
 	return parqw, nil
 }
+
+type Decoder struct{}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Decode reads the whole parquet file into memory and returns its content as a dataframe.
+// Only flat files with the column types produced by the Encoder are supported.
+func (d *Decoder) Decode(r io.Reader) (dataframe.Dataframe, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	parqr, err := reader.NewParquetColumnReader(buffer.NewBufferFileFromBytes(b), 4)
+	if err != nil {
+		return nil, errors.Wrap(err, "open parquet file")
+	}
+	defer parqr.ReadStop()
+
+	schema, err := readSchema(parqr)
+	if err != nil {
+		return nil, err
+	}
+	return readRows(parqr, schema, parqr.GetNumRows())
+}
+
+// Summarize reads the schema, number of rows and time range from the footer and the column statistics of the parquet
+// file, and decodes only the first limit rows. The file is read through range requests.
+func (d *Decoder) Summarize(ctx context.Context, bkt objstore.BucketReader, name string, limit int) (exporter.Summary, error) {
+	attrs, err := bkt.Attributes(ctx, name)
+	if err != nil {
+		return exporter.Summary{}, errors.Wrapf(err, "attributes of %s", name)
+	}
+	parqr, err := reader.NewParquetColumnReader(&bucketFile{ctx: ctx, bkt: bkt, name: name, size: attrs.Size}, 1)
+	if err != nil {
+		return exporter.Summary{}, errors.Wrap(err, "open parquet file")
+	}
+	defer parqr.ReadStop()
+
+	schema, err := readSchema(parqr)
+	if err != nil {
+		return exporter.Summary{}, err
+	}
+	s := exporter.Summary{Schema: schema, Rows: parqr.GetNumRows()}
+	for _, rg := range parqr.Footer.GetRowGroups() {
+		for c, col := range schema {
+			if col.Type != dataframe.TypeTime || c >= len(rg.GetColumns()) {
+				continue
+			}
+			stats := rg.GetColumns()[c].GetMetaData().GetStatistics()
+			// Time columns are stored as plain encoded INT64 milliseconds. Columns without values have no statistics.
+			if stats == nil || len(stats.GetMinValue()) != 8 || len(stats.GetMaxValue()) != 8 {
+				continue
+			}
+			mint := time.UnixMilli(int64(binary.LittleEndian.Uint64(stats.GetMinValue()))).UTC()
+			maxt := time.UnixMilli(int64(binary.LittleEndian.Uint64(stats.GetMaxValue()))).UTC()
+			if s.MinTime.IsZero() || mint.Before(s.MinTime) {
+				s.MinTime = mint
+			}
+			if s.MaxTime.IsZero() || maxt.After(s.MaxTime) {
+				s.MaxTime = maxt
+			}
+		}
+	}
+
+	n := int64(limit)
+	if n > s.Rows {
+		n = s.Rows
+	}
+	if s.Head, err = readRows(parqr, schema, n); err != nil {
+		return exporter.Summary{}, err
+	}
+	return s, nil
+}
+
+// readSchema returns the schema of the parquet file.
+func readSchema(parqr *reader.ParquetReader) (dataframe.Schema, error) {
+	var schema dataframe.Schema
+	// The first element is the schema root. Footer names are renamed by the reader, use the original ones.
+	for i, el := range parqr.Footer.GetSchema()[1:] {
+		name := parqr.SchemaHandler.Infos[i+1].ExName
+		if el.GetNumChildren() > 0 {
+			return nil, errors.Newf("nested column %s not supported", name)
+		}
+		t, err := columnType(name, el)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, dataframe.Column{Name: name, Type: t, Required: el.GetRepetitionType() == parquet.FieldRepetitionType_REQUIRED})
+	}
+	return schema, nil
+}
+
+// readRows decodes the first n rows of the parquet file.
+func readRows(parqr *reader.ParquetReader, schema dataframe.Schema, n int64) (dataframe.Dataframe, error) {
+	if n <= 0 {
+		return dataframe.FromBatches(schema), nil
+	}
+	batch := dataframe.Batch{Columns: make([]dataframe.ColumnVector, 0, len(schema))}
+	for c, col := range schema {
+		values, _, _, err := parqr.ReadColumnByIndex(int64(c), n)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading column %s", col.Name)
+		}
+		if int64(len(values)) != n {
+			return nil, errors.Newf("column %s has %d values, expected %d", col.Name, len(values), n)
+		}
+
+		var vec dataframe.ColumnVector
+		switch col.Type {
+		case dataframe.TypeString:
+			vec = decodeColumn(values, func(v interface{}) string { return v.(string) })
+		case dataframe.TypeFloat:
+			vec = decodeColumn(values, func(v interface{}) float64 { return v.(float64) })
+		case dataframe.TypeUint:
+			vec = decodeColumn(values, func(v interface{}) uint64 { return uint64(v.(int64)) })
+		case dataframe.TypeTime:
+			vec = decodeColumn(values, func(v interface{}) time.Time { return time.UnixMilli(v.(int64)).UTC() })
+		}
+		batch.Columns = append(batch.Columns, vec)
+	}
+	return dataframe.FromBatches(schema, batch), nil
+}
+
+// decodeColumn returns the vector of the converted values, nil values are null.
+func decodeColumn[T any](values []interface{}, convert func(interface{}) T) *dataframe.Vector[T] {
+	vec := &dataframe.Vector[T]{Values: make([]T, 0, len(values))}
+	for _, v := range values {
+		if v == nil {
+			vec.AppendNull()
+			continue
+		}
+		vec.Append(convert(v))
+	}
+	return vec
+}
+
+// columnType returns the dataframe type of the parquet column, as written by the Encoder.
+func columnType(name string, el *parquet.SchemaElement) (dataframe.Type, error) {
+	switch {
+	case el.GetType() == parquet.Type_BYTE_ARRAY:
+		return dataframe.TypeString, nil
+	case el.GetType() == parquet.Type_DOUBLE:
+		return dataframe.TypeFloat, nil
+	case el.GetType() == parquet.Type_INT64 && el.IsSetConvertedType() && el.GetConvertedType() == parquet.ConvertedType_TIMESTAMP_MILLIS:
+		return dataframe.TypeTime, nil
+	case el.GetType() == parquet.Type_INT64:
+		return dataframe.TypeUint, nil
+	default:
+		return "", errors.Newf("unsupported type %v of column %s", el.GetType(), name)
+	}
+}
+
+// maxRangeSize bounds the size of a single range request of the bucketFile.
+const maxRangeSize = 1 << 20
+
+// bucketFile implements source.ParquetFile reading the object from the bucket through range requests.
+type bucketFile struct {
+	ctx          context.Context
+	bkt          objstore.BucketReader
+	name         string
+	size, offset int64
+}
+
+func (f *bucketFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return f.offset, errors.Newf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return f.offset, errors.Newf("negative offset %d", offset)
+	}
+	f.offset = offset
+	return f.offset, nil
+}
+
+func (f *bucketFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if len(p) > maxRangeSize {
+		p = p[:maxRangeSize]
+	}
+	if left := f.size - f.offset; int64(len(p)) > left {
+		p = p[:left]
+	}
+
+	r, err := f.bkt.GetRange(f.ctx, f.name, f.offset, int64(len(p)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "get range of %s", f.name)
+	}
+	defer r.Close()
+
+	n, err := io.ReadFull(r, p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *bucketFile) Write([]byte) (int, error) {
+	return 0, errors.New("bucket file is read only")
+}
+
+func (f *bucketFile) Close() error { return nil }
+
+// Open returns another reader of the same object. The name of the file of the column chunks is ignored, as the Encoder
+// writes them into the same file.
+func (f *bucketFile) Open(string) (source.ParquetFile, error) {
+	c := *f
+	c.offset = 0
+	return &c, nil
+}
+
+func (f *bucketFile) Create(string) (source.ParquetFile, error) {
+	return nil, errors.New("bucket file is read only")
+}
@@ -0,0 +1,134 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/thanos-io/objstore"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/thanos-community/obslytics/pkg/dataframe"
+	"github.com/thanos-community/obslytics/pkg/exporter"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	schema := dataframe.Schema{
+		{Name: "__name__", Type: dataframe.TypeString, Required: true},
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "_sample_start", Type: dataframe.TypeTime, Required: true},
+		{Name: "_count", Type: dataframe.TypeUint, Required: true},
+		{Name: "_sum", Type: dataframe.TypeFloat},
+	}
+	df := dataframe.FromRows(schema, []dataframe.Row{
+		{"up", "a", start, uint64(2), 1.5},
+		{"up", "b", start.Add(time.Minute + 250*time.Millisecond), uint64(1), 42.0},
+		{"up", nil, start.Add(2 * time.Minute), uint64(0), nil},
+	})
+
+	b := &bytes.Buffer{}
+	testutil.Ok(t, NewEncoder().Encode(b, df))
+
+	got, err := NewDecoder().Decode(b)
+	testutil.Ok(t, err)
+	testutil.Equals(t, schema, got.Schema())
+
+	// Columns are decoded into typed vectors.
+	batches := got.Batches()
+	testutil.Assert(t, batches.Next())
+	jobs := batches.At().Columns[1].(*dataframe.StringVector)
+	testutil.Equals(t, []string{"a", "b", ""}, jobs.Values)
+	testutil.Assert(t, !jobs.IsNull(1) && jobs.IsNull(2))
+	testutil.Assert(t, !batches.Next())
+
+	var rows []dataframe.Row
+	for i := dataframe.Rows(got); i.Next(); {
+		rows = append(rows, i.At())
+	}
+	testutil.Equals(t, []dataframe.Row{
+		{"up", "a", start, uint64(2), 1.5},
+		{"up", "b", start.Add(time.Minute + 250*time.Millisecond), uint64(1), 42.0},
+		{"up", nil, start.Add(2 * time.Minute), uint64(0), nil},
+	}, rows)
+
+	// Required columns can't be null.
+	df = dataframe.FromRows(schema, []dataframe.Row{{nil, "a", start, uint64(2), 1.5}})
+	testutil.NotOk(t, NewEncoder().Encode(&bytes.Buffer{}, df))
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	schema := dataframe.Schema{
+		{Name: "job", Type: dataframe.TypeString},
+		{Name: "_sample_start", Type: dataframe.TypeTime, Required: true},
+		{Name: "_sample_end", Type: dataframe.TypeTime},
+		{Name: "_count", Type: dataframe.TypeUint, Required: true},
+	}
+	df := dataframe.FromRows(schema, []dataframe.Row{
+		{"a", start.Add(time.Minute), nil, uint64(2)},
+		{"b", start, start.Add(5 * time.Minute), uint64(1)},
+		{nil, start.Add(2 * time.Minute), nil, uint64(0)},
+	})
+
+	b := &bytes.Buffer{}
+	testutil.Ok(t, NewEncoder().Encode(b, df))
+	bkt := objstore.NewInMemBucket()
+	testutil.Ok(t, bkt.Upload(context.Background(), "out.parquet", b))
+
+	s, err := NewDecoder().Summarize(context.Background(), bkt, "out.parquet", 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, schema, s.Schema)
+	testutil.Equals(t, int64(3), s.Rows)
+	// Time range comes from the statistics of all time columns.
+	testutil.Equals(t, start, s.MinTime)
+	testutil.Equals(t, start.Add(5*time.Minute), s.MaxTime)
+	testutil.Equals(t, dataframe.ToString(dataframe.FromRows(schema, []dataframe.Row{
+		{"a", start.Add(time.Minute), nil, uint64(2)},
+		{"b", start, start.Add(5 * time.Minute), uint64(1)},
+	})), dataframe.ToString(s.Head))
+
+	// Limit over the number of rows returns all of them.
+	s, err = NewDecoder().Summarize(context.Background(), bkt, "out.parquet", 10)
+	testutil.Ok(t, err)
+	testutil.Equals(t, dataframe.ToString(df), dataframe.ToString(s.Head))
+
+	// Empty objects have no time range.
+	b.Reset()
+	testutil.Ok(t, NewEncoder().Encode(b, dataframe.FromRows(schema, nil)))
+	testutil.Ok(t, bkt.Upload(context.Background(), "empty.parquet", b))
+	s, err = NewDecoder().Summarize(context.Background(), bkt, "empty.parquet", 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(0), s.Rows)
+	testutil.Assert(t, s.MinTime.IsZero() && s.MaxTime.IsZero())
+
+	_, err = NewDecoder().Summarize(context.Background(), bkt, "missing.parquet", 2)
+	testutil.NotOk(t, err)
+}
+
+func TestEncodeWithManifest(t *testing.T) {
+	schema := dataframe.Schema{{Name: "job", Type: dataframe.TypeString}}
+	df := dataframe.FromRows(schema, []dataframe.Row{{"a"}, {"b"}})
+
+	b := &bytes.Buffer{}
+	testutil.Ok(t, NewEncoder().EncodeWithManifest(b, df, exporter.Manifest{Version: "v0.1.0", Query: "up", Series: 2}))
+
+	parqr, err := reader.NewParquetColumnReader(buffer.NewBufferFileFromBytes(b.Bytes()), 1)
+	testutil.Ok(t, err)
+	defer parqr.ReadStop()
+
+	kv := parqr.Footer.GetKeyValueMetadata()
+	testutil.Equals(t, 1, len(kv))
+	testutil.Equals(t, exporter.ManifestKey, kv[0].Key)
+
+	m := exporter.Manifest{}
+	testutil.Ok(t, json.Unmarshal([]byte(kv[0].GetValue()), &m))
+	testutil.Equals(t, exporter.Manifest{Version: "v0.1.0", Query: "up", Series: 2, Rows: 2}, m)
+}
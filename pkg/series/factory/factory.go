@@ -10,18 +10,33 @@ import (
 	"github.com/go-kit/log"
 
 	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/series/promql"
 	"github.com/thanos-community/obslytics/pkg/series/promread"
 	"github.com/thanos-community/obslytics/pkg/series/storeapi"
 )
 
 // NewSeriesReader creates series.Reader based on configuration file.
 func NewSeriesReader(logger log.Logger, cfg series.Config) (series.Reader, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid input configuration")
+	}
+
+	var (
+		r   series.Reader
+		err error
+	)
 	switch series.Type(strings.ToUpper(string(cfg.Type))) {
 	case series.REMOTEREAD:
-		return promread.NewSeries(logger, cfg)
+		r, err = promread.NewSeries(logger, cfg)
 	case series.STOREAPI:
-		return storeapi.NewSeries(logger, cfg)
+		r, err = storeapi.NewSeries(logger, cfg)
+	case series.PROMQL:
+		r, err = promql.NewSeries(logger, cfg)
 	default:
 		return nil, errors.Newf("unsupported Reader type %s", cfg.Type)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return series.NewRetryingReader(logger, r, cfg), nil
 }
@@ -0,0 +1,216 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package promql
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+	"github.com/thanos-community/obslytics/pkg/version"
+)
+
+// Series implements series.Reader on top of the Prometheus HTTP query_range API.
+type Series struct {
+	logger log.Logger
+	conf   series.Config
+	api    v1.API
+}
+
+func NewSeries(logger log.Logger, conf series.Config) (Series, error) {
+	rt, err := config_util.NewRoundTripperFromConfig(conf.HTTPClientConfig(), path.Join("obslytics", version.Version))
+	if err != nil {
+		return Series{}, errors.Wrap(err, "creating HTTP round tripper")
+	}
+	if len(conf.Headers) > 0 {
+		rt = &headersRoundTripper{headers: conf.Headers, next: rt}
+	}
+
+	client, err := api.NewClient(api.Config{Address: conf.Endpoint, RoundTripper: rt})
+	if err != nil {
+		return Series{}, errors.Wrap(err, "creating Prometheus API client")
+	}
+	return Series{logger: logger, conf: conf, api: v1.NewAPI(client)}, nil
+}
+
+// Read evaluates params.Query over the requested time range and exposes the resulting matrix
+// as series. The Matchers are ignored by this reader.
+func (i Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
+	if params.Query == "" {
+		return nil, errors.New("PROMQL reader requires a query")
+	}
+	if params.Step <= 0 {
+		return nil, errors.Newf("PROMQL reader requires positive step, got %v", params.Step)
+	}
+
+	val, warns, err := i.api.QueryRange(ctx, params.Query, v1.Range{
+		Start: params.MinTime,
+		End:   params.MaxTime,
+		Step:  params.Step,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(wrapRetryable(err), "query_range against %v", i.conf.Endpoint)
+	}
+	i.logWarnings("query_range", warns)
+
+	matrix, ok := val.(model.Matrix)
+	if !ok {
+		return nil, errors.Newf("unexpected query_range result type %v, expected matrix", val.Type())
+	}
+
+	ss := make([]storage.Series, 0, len(matrix))
+	for _, stream := range matrix {
+		ss = append(ss, newStreamSeries(stream))
+	}
+	// Keep the same ordering guarantees as other readers, sorted by labels.
+	sort.Slice(ss, func(a, b int) bool {
+		return labels.Compare(ss[a].Labels(), ss[b].Labels()) < 0
+	})
+	return series.FilterShard(series.NewListSet(ss...), params.Shard), nil
+}
+
+// ListSeries implements series.SeriesLister using the series API. It requires Matchers.
+func (i Series) ListSeries(ctx context.Context, params series.Params) (series.Set, error) {
+	match, err := selector(params)
+	if err != nil {
+		return nil, err
+	}
+	lsets, warns, err := i.api.Series(ctx, match, params.MinTime, params.MaxTime)
+	if err != nil {
+		return nil, errors.Wrapf(wrapRetryable(err), "series against %v", i.conf.Endpoint)
+	}
+	i.logWarnings("series", warns)
+
+	ss := make([]storage.Series, 0, len(lsets))
+	for _, lset := range lsets {
+		ss = append(ss, storage.NewListSeries(toLabels(model.Metric(lset)), nil))
+	}
+	sort.Slice(ss, func(a, b int) bool {
+		return labels.Compare(ss[a].Labels(), ss[b].Labels()) < 0
+	})
+	return series.FilterShard(series.NewListSet(ss...), params.Shard), nil
+}
+
+// LabelNames implements series.LabelsReader using the labels API.
+func (i Series) LabelNames(ctx context.Context, params series.Params) ([]string, error) {
+	match, err := selector(params)
+	if err != nil {
+		return nil, err
+	}
+	names, warns, err := i.api.LabelNames(ctx, match, params.MinTime, params.MaxTime)
+	if err != nil {
+		return nil, errors.Wrapf(wrapRetryable(err), "labels against %v", i.conf.Endpoint)
+	}
+	i.logWarnings("labels", warns)
+	return names, nil
+}
+
+// LabelValues implements series.LabelsReader using the label values API.
+func (i Series) LabelValues(ctx context.Context, name string, params series.Params) ([]string, error) {
+	match, err := selector(params)
+	if err != nil {
+		return nil, err
+	}
+	vals, warns, err := i.api.LabelValues(ctx, name, match, params.MinTime, params.MaxTime)
+	if err != nil {
+		return nil, errors.Wrapf(wrapRetryable(err), "label values against %v", i.conf.Endpoint)
+	}
+	i.logWarnings("label values", warns)
+
+	ret := make([]string, 0, len(vals))
+	for _, v := range vals {
+		ret = append(ret, string(v))
+	}
+	return ret, nil
+}
+
+// selector returns the series selector of the params in the format of the match[] API parameter.
+func selector(params series.Params) ([]string, error) {
+	if len(params.Matchers) == 0 {
+		return nil, errors.New("PROMQL reader requires matchers to list series and labels")
+	}
+	ms := make([]string, 0, len(params.Matchers))
+	for _, m := range params.Matchers {
+		ms = append(ms, m.String())
+	}
+	return []string{"{" + strings.Join(ms, ",") + "}"}, nil
+}
+
+// wrapRetryable marks server errors and timeouts as retryable.
+func wrapRetryable(err error) error {
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) && (apiErr.Type == v1.ErrServer || apiErr.Type == v1.ErrTimeout) {
+		return series.RetryableError(err)
+	}
+	return err
+}
+
+func (i Series) logWarnings(api string, warns v1.Warnings) {
+	for _, w := range warns {
+		level.Warn(i.logger).Log("msg", api+" returned warning", "warning", w)
+	}
+}
+
+// Close implements series.Reader.
+func (i Series) Close() error { return nil }
+
+func toLabels(m model.Metric) labels.Labels {
+	lbls := make(labels.Labels, 0, len(m))
+	for n, v := range m {
+		lbls = append(lbls, labels.Label{Name: string(n), Value: string(v)})
+	}
+	sort.Sort(lbls)
+	return lbls
+}
+
+func newStreamSeries(stream *model.SampleStream) storage.Series {
+	return &storage.SeriesEntry{
+		Lset: toLabels(stream.Metric),
+		SampleIteratorFn: func() chunkenc.Iterator {
+			return storage.NewListSeriesIterator(samplePairs(stream.Values))
+		},
+	}
+}
+
+// samplePairs implements storage.Samples.
+type samplePairs []model.SamplePair
+
+func (s samplePairs) Get(i int) tsdbutil.Sample { return samplePair(s[i]) }
+func (s samplePairs) Len() int                  { return len(s) }
+
+// samplePair implements tsdbutil.Sample.
+type samplePair model.SamplePair
+
+func (s samplePair) T() int64   { return int64(s.Timestamp) }
+func (s samplePair) V() float64 { return float64(s.Value) }
+
+// headersRoundTripper injects configured headers into every request.
+type headersRoundTripper struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (rt *headersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper must not modify the original request.
+	req = req.Clone(req.Context())
+	for k, v := range rt.headers {
+		req.Header.Set(k, v)
+	}
+	return rt.next.RoundTrip(req)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package promql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func TestPromQLInput_Read(t *testing.T) {
+	var gotQuery, gotStep string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, r.ParseForm())
+		testutil.Equals(t, "/api/v1/query_range", r.URL.Path)
+		gotQuery, gotStep = r.Form.Get("query"), r.Form.Get("step")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"team":"b"},"values":[[10,"3"],[40,"4"]]},
+			{"metric":{"team":"a"},"values":[[10,"1"],[40,"2"]]}
+		]}}`))
+	}))
+	defer srv.Close()
+
+	in, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: srv.URL, Type: series.PROMQL})
+	testutil.Ok(t, err)
+
+	set, err := in.Read(context.Background(), series.Params{
+		Query:   `sum by (team) (up)`,
+		MinTime: time.Unix(10, 0),
+		MaxTime: time.Unix(40, 0),
+		Step:    30 * time.Second,
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `sum by (team) (up)`, gotQuery)
+	testutil.Equals(t, "30", gotStep)
+
+	var got []string
+	var values []float64
+	for set.Next() {
+		s := set.At()
+		got = append(got, s.Labels().String())
+		it := s.Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			testutil.Assert(t, ts == 10000 || ts == 40000, "unexpected timestamp %v", ts)
+			values = append(values, v)
+		}
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, []string{`{team="a"}`, `{team="b"}`}, got)
+	testutil.Equals(t, []float64{1, 2, 3, 4}, values)
+
+	_, err = in.Read(context.Background(), series.Params{Step: time.Second})
+	testutil.NotOk(t, err)
+}
+
+func TestPromQLInput_ListSeriesAndLabels(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, r.ParseForm())
+		testutil.Equals(t, []string{`{__name__="up",job=~"a|b"}`}, r.Form["match[]"])
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/series":
+			_, _ = w.Write([]byte(`{"status":"success","data":[{"__name__":"up","job":"b"},{"__name__":"up","job":"a","instance":"1"}]}`))
+		case "/api/v1/labels":
+			_, _ = w.Write([]byte(`{"status":"success","data":["__name__","instance","job"]}`))
+		case "/api/v1/label/job/values":
+			_, _ = w.Write([]byte(`{"status":"success","data":["a","b"]}`))
+		default:
+			t.Errorf("unexpected path %v", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	in, err := NewSeries(log.NewNopLogger(), series.Config{Endpoint: srv.URL, Type: series.PROMQL})
+	testutil.Ok(t, err)
+
+	params := series.Params{
+		Matchers: []*labels.Matcher{
+			labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up"),
+			labels.MustNewMatcher(labels.MatchRegexp, "job", "a|b"),
+		},
+		MinTime: time.Unix(10, 0),
+		MaxTime: time.Unix(40, 0),
+	}
+	set, err := in.ListSeries(context.Background(), params)
+	testutil.Ok(t, err)
+	var got []string
+	for set.Next() {
+		got = append(got, set.At().Labels().String())
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, []string{`{__name__="up", instance="1", job="a"}`, `{__name__="up", job="b"}`}, got)
+
+	names, err := in.LabelNames(context.Background(), params)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"__name__", "instance", "job"}, names)
+
+	values, err := in.LabelValues(context.Background(), "job", params)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b"}, values)
+
+	_, err = in.LabelNames(context.Background(), series.Params{})
+	testutil.NotOk(t, err)
+}
@@ -5,8 +5,12 @@ package promread
 
 import (
 	"context"
+	"io"
+	"math"
+	"net/http"
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/efficientgo/core/errors"
 	"github.com/go-kit/log"
@@ -57,37 +61,43 @@ func TranslatePromMatchers(ms ...*labels.Matcher) ([]*prompb.LabelMatcher, error
 }
 
 func (i Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
-	tlsConfig := config_util.TLSConfig{
-		CAFile:             i.conf.TLSConfig.CAFile,
-		CertFile:           i.conf.TLSConfig.CertFile,
-		KeyFile:            i.conf.TLSConfig.KeyFile,
-		ServerName:         i.conf.TLSConfig.ServerName,
-		InsecureSkipVerify: i.conf.TLSConfig.InsecureSkipVerify,
-	}
+	return i.read(ctx, params, nil)
+}
 
-	httpConfig := config_util.HTTPClientConfig{
-		TLSConfig: tlsConfig,
-	}
+// ListSeries implements series.SeriesLister. Prometheus does not read chunks for queries with the "series" hint.
+func (i Series) ListSeries(ctx context.Context, params series.Params) (series.Set, error) {
+	return i.read(ctx, params, &prompb.ReadHints{
+		Func:    "series",
+		StartMs: timestamp.FromTime(params.MinTime),
+		EndMs:   timestamp.FromTime(params.MaxTime),
+	})
+}
 
+func (i Series) read(ctx context.Context, params series.Params, hints *prompb.ReadHints) (series.Set, error) {
 	parsedUrl, err := url.Parse(i.conf.Endpoint)
 	if err != nil {
 		return nil, err
 	}
-	timeoutDuration, err := model.ParseDuration("10s")
-	if err != nil {
-		return nil, err
+	// Remote read client always applies its timeout. With no timeout configured, rely on the context only.
+	timeout := i.conf.Timeout
+	if timeout <= 0 {
+		timeout = model.Duration(math.MaxInt64)
 	}
 
 	clientConfig := &remote.ClientConfig{
 		URL:              &config_util.URL{URL: parsedUrl},
-		Timeout:          timeoutDuration,
-		HTTPClientConfig: httpConfig,
+		Timeout:          timeout,
+		HTTPClientConfig: i.conf.HTTPClientConfig(),
+		Headers:          i.conf.Headers,
 	}
 
 	client, err := remote.NewReadClient(path.Join("obslytics", version.Version), clientConfig)
 	if err != nil {
 		return nil, err
 	}
+	if c, ok := client.(*remote.Client); ok {
+		c.Client.Transport = retryableStatusRoundTripper{RoundTripper: c.Client.Transport}
+	}
 
 	promLabelMatchers, err := TranslatePromMatchers(params.Matchers...)
 	if err != nil {
@@ -99,6 +109,7 @@ func (i Series) Read(ctx context.Context, params series.Params) (series.Set, err
 		StartTimestampMs: timestamp.FromTime(params.MinTime),
 		EndTimestampMs:   timestamp.FromTime(params.MaxTime),
 		Matchers:         promLabelMatchers,
+		Hints:            hints,
 	}
 	// TODO: Move to streaming remote read version when available.
 	readResponse, err := client.Read(ctx, query)
@@ -115,12 +126,34 @@ func (i Series) Read(ctx context.Context, params series.Params) (series.Set, err
 		})
 	}
 
-	return &iterator{
+	// Remote read does not support sharding, filter the series on the client side.
+	return series.FilterShard(&iterator{
 		ctx:                ctx,
 		client:             client,
 		seriesList:         readSeriesList,
 		currentSeriesIndex: -1,
-	}, nil
+	}, params.Shard), nil
+}
+
+// Close implements series.Reader.
+func (i Series) Close() error { return nil }
+
+// retryableStatusRoundTripper fails requests answered with server error or throttling status with retryable error,
+// as the remote read client does not expose the status code.
+type retryableStatusRoundTripper struct {
+	http.RoundTripper
+}
+
+func (rt retryableStatusRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.RoundTripper.RoundTrip(req)
+	if err != nil || (resp.StatusCode/100 != 5 && resp.StatusCode != http.StatusTooManyRequests) {
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	// The body describes the error, but might be large.
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, series.RetryableError(errors.Newf("remote server %s returned HTTP status %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body))))
 }
 
 // iterator implements input.Set.
@@ -7,6 +7,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -14,6 +16,8 @@ import (
 	"github.com/efficientgo/core/testutil"
 	"github.com/efficientgo/e2e"
 	e2emon "github.com/efficientgo/e2e/monitoring"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	http_util "github.com/thanos-io/thanos/pkg/exthttp"
@@ -140,3 +144,33 @@ func TestRemoteReadInput_Open(t *testing.T) {
 
 	})
 }
+
+func TestRemoteReadInput_RetryableStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		reads  int
+	}{
+		{status: http.StatusServiceUnavailable, reads: 2},
+		{status: http.StatusTooManyRequests, reads: 2},
+		// Client errors are permanent.
+		{status: http.StatusBadRequest, reads: 1},
+	} {
+		var reads int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			reads++
+			http.Error(w, "failed", tc.status)
+		}))
+
+		conf := series.Config{Endpoint: srv.URL + "/api/v1/read", Retries: 1, Backoff: series.BackoffConfig{MinPeriod: model.Duration(time.Millisecond), MaxPeriod: model.Duration(time.Millisecond)}}
+		in, err := NewSeries(log.NewNopLogger(), conf)
+		testutil.Ok(t, err)
+		_, err = series.NewRetryingReader(log.NewNopLogger(), in, conf).Read(context.Background(), series.Params{
+			Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")},
+			MinTime:  time.Unix(0, 0),
+			MaxTime:  time.Unix(60, 0),
+		})
+		srv.Close()
+		testutil.NotOk(t, err)
+		testutil.Equals(t, tc.reads, reads, http.StatusText(tc.status))
+	}
+}
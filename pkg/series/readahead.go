@@ -0,0 +1,163 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package series
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/gate"
+)
+
+// ReadAhead returns Set iterating through the series read for all given params, one after another, the same way
+// as ReadChained does. Up to `ahead` reads are issued concurrently ahead of the iteration and their series are
+// buffered in memory. The gate limits concurrent reads and can be shared between many sets.
+func ReadAhead(ctx context.Context, r Reader, g gate.Gate, ahead int, params ...Params) Set {
+	if ahead < 1 {
+		ahead = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &readAheadSet{
+		cancel:  cancel,
+		results: make([]chan readAheadResult, len(params)),
+		slots:   make(chan struct{}, ahead),
+	}
+	for i := range s.results {
+		s.results[i] = make(chan readAheadResult, 1)
+	}
+
+	go func() {
+		for i, p := range params {
+			// Limit the number of buffered, not yet iterated sets.
+			select {
+			case s.slots <- struct{}{}:
+			case <-ctx.Done():
+				s.results[i] <- readAheadResult{err: ctx.Err()}
+				return
+			}
+
+			go func(i int, p Params) {
+				if err := g.Start(ctx); err != nil {
+					s.results[i] <- readAheadResult{err: err}
+					return
+				}
+				defer g.Done()
+
+				set, err := r.Read(ctx, p)
+				if err == nil {
+					set, err = bufferSet(set)
+				}
+				s.results[i] <- readAheadResult{set: set, err: err}
+			}(i, p)
+		}
+	}()
+	return s
+}
+
+type readAheadResult struct {
+	set Set
+	err error
+}
+
+// readAheadSet implements Set.
+type readAheadSet struct {
+	cancel  context.CancelFunc
+	results []chan readAheadResult
+	slots   chan struct{}
+
+	pos      int
+	cur      Set
+	warnings storage.Warnings
+	err      error
+}
+
+func (s *readAheadSet) Next() bool {
+	for s.err == nil {
+		if s.cur == nil {
+			if s.pos >= len(s.results) {
+				return false
+			}
+			res := <-s.results[s.pos]
+			if res.err != nil {
+				s.err = res.err
+				return false
+			}
+			s.cur = res.set
+		}
+		if s.cur.Next() {
+			return true
+		}
+		if s.err = s.cur.Err(); s.err != nil {
+			return false
+		}
+		s.warnings = append(s.warnings, s.cur.Warnings()...)
+		s.err = s.cur.Close()
+		s.cur = nil
+		s.pos++
+		// Allow next read to be issued.
+		<-s.slots
+	}
+	return false
+}
+
+func (s *readAheadSet) At() storage.Series { return s.cur.At() }
+
+func (s *readAheadSet) Warnings() storage.Warnings {
+	if s.cur != nil {
+		return append(s.warnings, s.cur.Warnings()...)
+	}
+	return s.warnings
+}
+
+func (s *readAheadSet) Err() error { return s.err }
+
+// Close cancels all reads in progress. Sets buffered so far are dropped.
+func (s *readAheadSet) Close() error {
+	s.cancel()
+	return nil
+}
+
+// bufferSet reads all series and samples of the set into memory and closes it.
+func bufferSet(set Set) (_ Set, err error) {
+	if _, ok := set.(*listSet); ok {
+		// Already in memory.
+		return set, nil
+	}
+	defer func() {
+		if cerr := set.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	var ss []storage.Series
+	for set.Next() {
+		s := set.At()
+
+		var samples []tsdbutil.Sample
+		it := s.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			samples = append(samples, sample{t: t, v: v})
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		ss = append(ss, storage.NewListSeries(s.Labels(), samples))
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+	return NewListSet(ss...), nil
+}
+
+// sample implements tsdbutil.Sample.
+type sample struct {
+	t int64
+	v float64
+}
+
+func (s sample) T() int64   { return s.t }
+func (s sample) V() float64 { return s.v }
@@ -0,0 +1,61 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package series
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/thanos-io/thanos/pkg/gate"
+)
+
+// slowReader returns single series labeled with the read min time. The earlier the time range, the slower the read.
+type slowReader struct {
+	inflight, maxInflight int64
+}
+
+func (r *slowReader) Read(_ context.Context, p Params) (Set, error) {
+	n := atomic.AddInt64(&r.inflight, 1)
+	defer atomic.AddInt64(&r.inflight, -1)
+	for {
+		m := atomic.LoadInt64(&r.maxInflight)
+		if n <= m || atomic.CompareAndSwapInt64(&r.maxInflight, m, n) {
+			break
+		}
+	}
+
+	time.Sleep(time.Duration(10-p.MinTime.Unix()) * time.Millisecond)
+	return NewListSet(storage.NewListSeries(
+		labels.FromStrings("mint", fmt.Sprintf("%d", p.MinTime.Unix())),
+		[]tsdbutil.Sample{sample{t: p.MinTime.UnixMilli(), v: 1}},
+	)), nil
+}
+
+func (r *slowReader) Close() error { return nil }
+
+func TestReadAhead(t *testing.T) {
+	var params []Params
+	for i := 0; i < 10; i++ {
+		params = append(params, Params{MinTime: time.Unix(int64(i), 0), MaxTime: time.Unix(int64(i+1), 0)})
+	}
+
+	r := &slowReader{}
+	set := ReadAhead(context.Background(), r, gate.New(nil, 3), 5, params...)
+	defer func() { testutil.Ok(t, set.Close()) }()
+
+	var got []string
+	for set.Next() {
+		got = append(got, set.At().Labels().Get("mint"))
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, got)
+	testutil.Assert(t, atomic.LoadInt64(&r.maxInflight) <= 3, "expected at most 3 concurrent reads, got %v", r.maxInflight)
+}
@@ -0,0 +1,263 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package series
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/efficientgo/core/backoff"
+	"github.com/efficientgo/core/errors"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultBackoffMinPeriod = 500 * time.Millisecond
+	defaultBackoffMaxPeriod = 10 * time.Second
+)
+
+// BackoffConfig configures the wait time between read retries.
+type BackoffConfig struct {
+	MinPeriod model.Duration `yaml:"min_period"`
+	MaxPeriod model.Duration `yaml:"max_period"`
+}
+
+// RetryableError marks the error as transient, so the read failing with it can be retried.
+func RetryableError(err error) error {
+	return retryableError{err: err}
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+// NewRetryingReader wraps the reader, so every read attempt is bounded by the configured timeout and reads
+// failing on transient errors are retried with backoff, up to the configured number of retries.
+// Series are streamed, so reads are retried only if they fail before returning the first series, e.g. on connection
+// errors or unavailable endpoint. Errors in the middle of the stream are returned, as the series already returned
+// would be duplicated. Use smaller time ranges (see Params.SplitByInterval) to limit the range to read again.
+func NewRetryingReader(logger log.Logger, r Reader, conf Config) Reader {
+	if conf.Timeout <= 0 && conf.Retries <= 0 {
+		return r
+	}
+
+	cfg := backoff.Config{
+		Min: time.Duration(conf.Backoff.MinPeriod),
+		Max: time.Duration(conf.Backoff.MaxPeriod),
+	}
+	if cfg.Min <= 0 {
+		cfg.Min = defaultBackoffMinPeriod
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = defaultBackoffMaxPeriod
+	}
+	return &retryingReader{logger: logger, r: r, timeout: time.Duration(conf.Timeout), retries: conf.Retries, backoff: cfg}
+}
+
+// retryingReader implements Reader.
+type retryingReader struct {
+	logger  log.Logger
+	r       Reader
+	timeout time.Duration
+	retries int
+	backoff backoff.Config
+}
+
+func (r *retryingReader) Read(ctx context.Context, params Params) (Set, error) {
+	return r.retry(ctx, params, r.r.Read)
+}
+
+// ListSeries implements SeriesLister if the wrapped reader does.
+func (r *retryingReader) ListSeries(ctx context.Context, params Params) (Set, error) {
+	l, ok := r.r.(SeriesLister)
+	if !ok {
+		return nil, ErrListingNotSupported
+	}
+	return r.retry(ctx, params, l.ListSeries)
+}
+
+// LabelNames implements LabelsReader if the wrapped reader does.
+func (r *retryingReader) LabelNames(ctx context.Context, params Params) (names []string, err error) {
+	lr, ok := r.r.(LabelsReader)
+	if !ok {
+		return nil, ErrLabelsNotSupported
+	}
+	err = r.do(ctx, params, func(ctx context.Context) error {
+		names, err = lr.LabelNames(ctx, params)
+		return err
+	})
+	return names, err
+}
+
+// LabelValues implements LabelsReader if the wrapped reader does.
+func (r *retryingReader) LabelValues(ctx context.Context, name string, params Params) (values []string, err error) {
+	lr, ok := r.r.(LabelsReader)
+	if !ok {
+		return nil, ErrLabelsNotSupported
+	}
+	err = r.do(ctx, params, func(ctx context.Context) error {
+		values, err = lr.LabelValues(ctx, name, params)
+		return err
+	})
+	return values, err
+}
+
+// do calls f, bounding every attempt by the timeout and retrying it on transient errors.
+func (r *retryingReader) do(ctx context.Context, params Params, f func(context.Context) error) error {
+	return r.retryAttempts(ctx, params, func() error {
+		if r.timeout <= 0 {
+			return f(ctx)
+		}
+		ctx, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+		return f(ctx)
+	})
+}
+
+// retryAttempts calls the attempt, retrying it with backoff on transient errors.
+func (r *retryingReader) retryAttempts(ctx context.Context, params Params, attempt func() error) error {
+	if r.retries <= 0 {
+		return attempt()
+	}
+
+	b := backoff.New(ctx, r.backoff)
+	for {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+		// Do not wait once the retries are exhausted.
+		if b.NumRetries() >= r.retries {
+			return errors.Wrapf(err, "read failed after %d retries", r.retries)
+		}
+		level.Warn(r.logger).Log("msg", "read failed, retrying", "attempt", b.NumRetries()+1, "mint", params.MinTime, "maxt", params.MaxTime, "err", err)
+		b.Wait()
+	}
+}
+
+// retry issues the read, retrying it on transient errors until the first series is returned.
+func (r *retryingReader) retry(ctx context.Context, params Params, read readFunc) (set Set, err error) {
+	if r.retries <= 0 {
+		return r.read(ctx, params, read)
+	}
+
+	err = r.retryAttempts(ctx, params, func() error {
+		s, err := r.read(ctx, params, read)
+		if err != nil {
+			return err
+		}
+		set, err = startSet(s)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+type readFunc func(context.Context, Params) (Set, error)
+
+// read issues a single read attempt bounded by the timeout. The timeout covers the iteration of the returned set.
+func (r *retryingReader) read(ctx context.Context, params Params, read readFunc) (Set, error) {
+	if r.timeout <= 0 {
+		return read(ctx, params)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	set, err := read(ctx, params)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelOnCloseSet{Set: set, cancel: cancel}, nil
+}
+
+func (r *retryingReader) Close() error {
+	return r.r.Close()
+}
+
+// cancelOnCloseSet cancels the read context once the set is closed.
+type cancelOnCloseSet struct {
+	Set
+	cancel context.CancelFunc
+}
+
+func (s *cancelOnCloseSet) Close() error {
+	defer s.cancel()
+	return s.Set.Close()
+}
+
+// startSet advances the set to its first series, so streams failing before returning any series (e.g. StoreAPI
+// failing on the first receive) can be read again. The set is closed on error.
+func startSet(set Set) (Set, error) {
+	if set.Next() {
+		return &startedSet{Set: set}, nil
+	}
+	if err := set.Err(); err != nil {
+		// TODO(bwplotka): Log error from close (e.g using runutil.Close... package).
+		_ = set.Close()
+		return nil, err
+	}
+	return &startedSet{Set: set, empty: true}, nil
+}
+
+// startedSet returns the series the set was already advanced to first.
+type startedSet struct {
+	Set
+	started, empty bool
+}
+
+func (s *startedSet) Next() bool {
+	if !s.started {
+		s.started = true
+		return !s.empty
+	}
+	return !s.empty && s.Set.Next()
+}
+
+// isRetryable returns true if the read failed on a transient error, e.g. unavailable endpoint, throttling, refused
+// or reset connection or timeout of the attempt. Errors of the server (e.g. gRPC Internal) and DNS or TLS errors are
+// considered permanent.
+func isRetryable(err error) bool {
+	var retryable retryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		switch grpcErr.GRPCStatus().Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+			return true
+		default:
+			return false
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,138 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package series
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/errors"
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyReader returns all series, but fails the iteration before the first series for the first `failures` reads,
+// or after the first series if midStream is set.
+type flakyReader struct {
+	series    []storage.Series
+	err       error
+	failures  int
+	midStream bool
+	reads     int
+}
+
+func (r *flakyReader) Read(context.Context, Params) (Set, error) {
+	r.reads++
+	if r.reads <= r.failures {
+		if r.midStream {
+			return &failingSet{Set: NewListSet(r.series[0]), err: r.err}, nil
+		}
+		return &failingSet{Set: NewListSet(), err: r.err}, nil
+	}
+	return NewListSet(r.series...), nil
+}
+
+func (r *flakyReader) Close() error { return nil }
+
+type failingSet struct {
+	Set
+	err error
+}
+
+func (s *failingSet) Err() error { return s.err }
+
+func TestRetryingReader(t *testing.T) {
+	ss := []storage.Series{
+		storage.NewListSeries(labels.FromStrings("a", "1"), []tsdbutil.Sample{sample{t: 1, v: 1}}),
+		storage.NewListSeries(labels.FromStrings("a", "2"), []tsdbutil.Sample{sample{t: 1, v: 2}}),
+	}
+	conf := Config{Retries: 2, Backoff: BackoffConfig{MinPeriod: model.Duration(time.Millisecond), MaxPeriod: model.Duration(time.Millisecond)}}
+
+	t.Run("transient error is retried", func(t *testing.T) {
+		in := &flakyReader{series: ss, failures: 2, err: status.Error(codes.Unavailable, "unavailable")}
+		set, err := NewRetryingReader(log.NewNopLogger(), in, conf).Read(context.Background(), Params{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, 3, in.reads)
+
+		var got []string
+		for set.Next() {
+			got = append(got, set.At().Labels().String())
+		}
+		testutil.Ok(t, set.Err())
+		testutil.Equals(t, []string{`{a="1"}`, `{a="2"}`}, got)
+	})
+	t.Run("error in the middle of the stream is not retried", func(t *testing.T) {
+		in := &flakyReader{series: ss, failures: 1, midStream: true, err: status.Error(codes.Unavailable, "unavailable")}
+		set, err := NewRetryingReader(log.NewNopLogger(), in, conf).Read(context.Background(), Params{})
+		testutil.Ok(t, err)
+
+		var got []string
+		for set.Next() {
+			got = append(got, set.At().Labels().String())
+		}
+		// Series already returned would be duplicated.
+		testutil.NotOk(t, set.Err())
+		testutil.Equals(t, []string{`{a="1"}`}, got)
+		testutil.Equals(t, 1, in.reads)
+	})
+	t.Run("empty result", func(t *testing.T) {
+		set, err := NewRetryingReader(log.NewNopLogger(), &flakyReader{}, conf).Read(context.Background(), Params{})
+		testutil.Ok(t, err)
+		testutil.Assert(t, !set.Next())
+		testutil.Ok(t, set.Err())
+	})
+	t.Run("retries are exhausted", func(t *testing.T) {
+		in := &flakyReader{series: ss, failures: 3, err: errors.Wrap(status.Error(codes.Unavailable, "unavailable"), "recv")}
+		_, err := NewRetryingReader(log.NewNopLogger(), in, conf).Read(context.Background(), Params{})
+		testutil.NotOk(t, err)
+		testutil.Equals(t, 3, in.reads)
+	})
+	t.Run("no backoff after the last retry", func(t *testing.T) {
+		period := model.Duration(200 * time.Millisecond)
+		in := &flakyReader{series: ss, failures: 2, err: status.Error(codes.Unavailable, "unavailable")}
+		begin := time.Now()
+		_, err := NewRetryingReader(log.NewNopLogger(), in, Config{Retries: 1, Backoff: BackoffConfig{MinPeriod: period, MaxPeriod: period}}).Read(context.Background(), Params{})
+		testutil.NotOk(t, err)
+		testutil.Equals(t, 2, in.reads)
+		testutil.Assert(t, time.Since(begin) < 2*time.Duration(period), "waited after the last retry")
+	})
+	t.Run("permanent error is not retried", func(t *testing.T) {
+		in := &flakyReader{series: ss, failures: 1, err: status.Error(codes.InvalidArgument, "bad matchers")}
+		_, err := NewRetryingReader(log.NewNopLogger(), in, conf).Read(context.Background(), Params{})
+		testutil.NotOk(t, err)
+		testutil.Equals(t, 1, in.reads)
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{err: status.Error(codes.Unavailable, "unavailable"), retryable: true},
+		{err: errors.Wrap(status.Error(codes.ResourceExhausted, "throttled"), "recv"), retryable: true},
+		{err: status.Error(codes.Internal, "panic"), retryable: false},
+		{err: status.Error(codes.InvalidArgument, "bad matchers"), retryable: false},
+		{err: RetryableError(errors.New("HTTP status 503")), retryable: true},
+		{err: errors.Wrap(context.DeadlineExceeded, "read"), retryable: true},
+		{err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, retryable: true},
+		{err: &url.Error{Op: "Post", URL: "http://prometheus", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, retryable: false},
+		{err: &url.Error{Op: "Post", URL: "http://prometheus", Err: &net.DNSError{Err: "timeout", IsTimeout: true}}, retryable: true},
+		{err: &url.Error{Op: "Post", URL: "https://prometheus", Err: errors.New("x509: certificate signed by unknown authority")}, retryable: false},
+		{err: errors.New("unknown"), retryable: false},
+	} {
+		testutil.Equals(t, tc.retryable, isRetryable(tc.err), tc.err.Error())
+	}
+}
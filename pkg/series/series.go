@@ -5,11 +5,16 @@ package series
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/efficientgo/core/errors"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	http_util "github.com/thanos-io/thanos/pkg/exthttp"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
 type Type string
@@ -17,6 +22,7 @@ type Type string
 const (
 	REMOTEREAD Type = "REMOTEREAD"
 	STOREAPI   Type = "STOREAPI"
+	PROMQL     Type = "PROMQL"
 )
 
 // Config contains the options determining the endpoint to talk to.
@@ -24,6 +30,47 @@ type Config struct {
 	Endpoint  string              `yaml:"endpoint"`
 	TLSConfig http_util.TLSConfig `yaml:"tls_config"`
 	Type      Type                `yaml:"type"`
+
+	// Authentication options. At most one of bearer token (file), basic auth and OAuth2 can be configured.
+	BearerToken     config_util.Secret     `yaml:"bearer_token"`
+	BearerTokenFile string                 `yaml:"bearer_token_file"`
+	BasicAuth       *config_util.BasicAuth `yaml:"basic_auth"`
+	OAuth2          *config_util.OAuth2    `yaml:"oauth2"`
+	// Headers are added to every request, e.g. X-Scope-OrgID for multi-tenant endpoints.
+	Headers map[string]string `yaml:"headers"`
+	// AllowInsecureCredentials allows sending the credentials to StoreAPI over plain text gRPC connection without
+	// TLS, e.g. within the network of a proxy terminating TLS.
+	AllowInsecureCredentials bool `yaml:"allow_insecure_credentials"`
+
+	// Timeout of a single read attempt, including iterating through all returned series. 0 means no timeout.
+	Timeout model.Duration `yaml:"timeout"`
+	// Retries is the number of times a read failing on a transient error is retried. 0 disables retries.
+	Retries int           `yaml:"retries"`
+	Backoff BackoffConfig `yaml:"backoff"`
+}
+
+// Validate checks if the authentication options are consistent.
+func (c Config) Validate() error {
+	httpConfig := c.HTTPClientConfig()
+	return httpConfig.Validate()
+}
+
+// HTTPClientConfig returns the Prometheus HTTP client configuration for HTTP based readers.
+// NOTE: Headers are not part of it, and have to be injected by the reader.
+func (c Config) HTTPClientConfig() config_util.HTTPClientConfig {
+	return config_util.HTTPClientConfig{
+		TLSConfig: config_util.TLSConfig{
+			CAFile:             c.TLSConfig.CAFile,
+			CertFile:           c.TLSConfig.CertFile,
+			KeyFile:            c.TLSConfig.KeyFile,
+			ServerName:         c.TLSConfig.ServerName,
+			InsecureSkipVerify: c.TLSConfig.InsecureSkipVerify,
+		},
+		BearerToken:     c.BearerToken,
+		BearerTokenFile: c.BearerTokenFile,
+		BasicAuth:       c.BasicAuth,
+		OAuth2:          c.OAuth2,
+	}
 }
 
 // Params determines what data should be loaded from the input.
@@ -31,15 +78,211 @@ type Params struct {
 	Matchers []*labels.Matcher
 	MinTime  time.Time
 	MaxTime  time.Time
+
+	// Query is the PromQL expression to evaluate. Used by the PROMQL reader instead of Matchers.
+	Query string
+	// Step is the query resolution step of the PromQL evaluation.
+	Step time.Duration
+
+	// Shard selects only the subset of series falling into the given shard. Nil means all series are read.
+	Shard *ShardInfo
+}
+
+// ShardInfo selects series by the hash of their labels, the same way as StoreAPI sharding does.
+type ShardInfo struct {
+	Index int
+	Count int
+}
+
+// StorepbShardInfo returns the StoreAPI representation of the shard, sharding by all series labels.
+func (s *ShardInfo) StorepbShardInfo() *storepb.ShardInfo {
+	if s == nil || s.Count <= 1 {
+		return nil
+	}
+	return &storepb.ShardInfo{ShardIndex: int64(s.Index), TotalShards: int64(s.Count)}
+}
+
+// FilterShard returns Set with only series belonging to the given shard.
+func FilterShard(set Set, shard *ShardInfo) Set {
+	info := shard.StorepbShardInfo()
+	if info == nil {
+		return set
+	}
+	buffers := &sync.Pool{New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	}}
+	return &shardSet{Set: set, matcher: info.Matcher(buffers)}
 }
 
+// shardSet implements Set.
+type shardSet struct {
+	Set
+	matcher *storepb.ShardMatcher
+}
+
+func (s *shardSet) Next() bool {
+	for s.Set.Next() {
+		if s.matcher.MatchesLabels(s.Set.At().Labels()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *shardSet) Close() error {
+	s.matcher.Close()
+	return s.Set.Close()
+}
+
+// SplitByInterval splits the time range of the params into consecutive, non-overlapping sub-ranges. The boundaries
+// between sub-ranges are aligned to multiples of the interval, the same way as time.Time.Truncate does. Returns
+// the params unchanged if interval is not positive.
+func (p Params) SplitByInterval(interval time.Duration) []Params {
+	if interval <= 0 {
+		return []Params{p}
+	}
+
+	var ret []Params
+	for start := p.MinTime; !start.After(p.MaxTime); {
+		// Time ranges are inclusive on both ends, end the sub-range just before the next boundary.
+		next := start.Truncate(interval).Add(interval)
+		end := next.Add(-time.Millisecond)
+		if end.After(p.MaxTime) {
+			end = p.MaxTime
+		}
+
+		sub := p
+		sub.MinTime, sub.MaxTime = start, end
+		ret = append(ret, sub)
+		start = next
+	}
+	return ret
+}
+
+// Reader reads series from the configured endpoint. The same Reader can be used for many reads, until closed.
 type Reader interface {
 	Read(context.Context, Params) (Set, error)
+	Close() error
+}
+
+// SeriesLister is implemented by readers able to list the series matching the params without reading their
+// samples, e.g. to estimate the size of an export.
+type SeriesLister interface {
+	// ListSeries returns the series matching the params. The returned series are not expected to have samples.
+	ListSeries(context.Context, Params) (Set, error)
 }
 
+// ErrListingNotSupported is returned when the reader does not support listing series.
+var ErrListingNotSupported = errors.New("listing series without samples is not supported by the input")
+
+// ListSeries lists the series matching the params without reading their samples, if supported by the reader.
+func ListSeries(ctx context.Context, r Reader, params Params) (Set, error) {
+	l, ok := r.(SeriesLister)
+	if !ok {
+		return nil, ErrListingNotSupported
+	}
+	return l.ListSeries(ctx, params)
+}
+
+// LabelsReader is implemented by readers able to query label names and values of the series matching the params.
+type LabelsReader interface {
+	LabelNames(context.Context, Params) ([]string, error)
+	LabelValues(ctx context.Context, name string, params Params) ([]string, error)
+}
+
+// ErrLabelsNotSupported is returned when the reader does not support querying labels.
+var ErrLabelsNotSupported = errors.New("querying labels is not supported by the input")
+
 // Set allows iterating through all series in tn the input.
 // The set is expected to iterate series by series. The same series can be partitioned between multiple iterations.
 type Set interface {
 	storage.SeriesSet
 	Close() error
 }
+
+// NewListSet returns Set iterating over the given in-memory series.
+func NewListSet(ss ...storage.Series) Set {
+	return &listSet{series: ss, pos: -1}
+}
+
+// listSet implements Set.
+type listSet struct {
+	series []storage.Series
+	pos    int
+}
+
+func (s *listSet) Next() bool {
+	if s.pos+1 >= len(s.series) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *listSet) At() storage.Series         { return s.series[s.pos] }
+func (s *listSet) Warnings() storage.Warnings { return nil }
+func (s *listSet) Err() error                 { return nil }
+func (s *listSet) Close() error               { return nil }
+
+// ReadChained returns Set iterating through the series read for all given params, one after another.
+// Each read is issued only once the set of the previous one is exhausted.
+func ReadChained(ctx context.Context, r Reader, params ...Params) Set {
+	return &chainedSet{ctx: ctx, r: r, params: params}
+}
+
+// chainedSet implements Set.
+type chainedSet struct {
+	ctx    context.Context
+	r      Reader
+	params []Params
+
+	pos      int
+	cur      Set
+	warnings storage.Warnings
+	err      error
+}
+
+func (s *chainedSet) Next() bool {
+	for s.err == nil {
+		if s.cur == nil {
+			if s.pos >= len(s.params) {
+				return false
+			}
+			s.cur, s.err = s.r.Read(s.ctx, s.params[s.pos])
+			if s.err != nil {
+				s.cur = nil
+				return false
+			}
+		}
+		if s.cur.Next() {
+			return true
+		}
+		if s.err = s.cur.Err(); s.err != nil {
+			return false
+		}
+		s.warnings = append(s.warnings, s.cur.Warnings()...)
+		s.err = s.cur.Close()
+		s.cur = nil
+		s.pos++
+	}
+	return false
+}
+
+func (s *chainedSet) At() storage.Series { return s.cur.At() }
+
+func (s *chainedSet) Warnings() storage.Warnings {
+	if s.cur != nil {
+		return append(s.warnings, s.cur.Warnings()...)
+	}
+	return s.warnings
+}
+
+func (s *chainedSet) Err() error { return s.err }
+
+func (s *chainedSet) Close() error {
+	if s.cur == nil {
+		return nil
+	}
+	return s.cur.Close()
+}
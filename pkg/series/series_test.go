@@ -0,0 +1,57 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package series
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestParams_SplitByInterval(t *testing.T) {
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := Params{MinTime: base.Add(30 * time.Minute), MaxTime: base.Add(3 * time.Hour)}
+
+	testutil.Equals(t, []Params{p}, p.SplitByInterval(0))
+
+	var got [][2]time.Time
+	for _, sub := range p.SplitByInterval(time.Hour) {
+		got = append(got, [2]time.Time{sub.MinTime, sub.MaxTime})
+	}
+	testutil.Equals(t, [][2]time.Time{
+		{base.Add(30 * time.Minute), base.Add(time.Hour - time.Millisecond)},
+		{base.Add(time.Hour), base.Add(2*time.Hour - time.Millisecond)},
+		{base.Add(2 * time.Hour), base.Add(3*time.Hour - time.Millisecond)},
+		{base.Add(3 * time.Hour), base.Add(3 * time.Hour)},
+	}, got)
+}
+
+func TestFilterShard(t *testing.T) {
+	var ss []storage.Series
+	for i := 0; i < 100; i++ {
+		ss = append(ss, storage.NewListSeries(labels.FromStrings("i", fmt.Sprintf("%d", i)), nil))
+	}
+
+	seen := map[string]int{}
+	for idx := 0; idx < 3; idx++ {
+		set := FilterShard(NewListSet(ss...), &ShardInfo{Index: idx, Count: 3})
+		n := 0
+		for set.Next() {
+			seen[set.At().Labels().String()]++
+			n++
+		}
+		testutil.Ok(t, set.Err())
+		testutil.Ok(t, set.Close())
+		testutil.Assert(t, n > 0, "expected some series in shard %d", idx)
+	}
+	// Every series is exactly in one shard.
+	testutil.Equals(t, 100, len(seen))
+	for l, n := range seen {
+		testutil.Equals(t, 1, n, "series %s", l)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package storeapi
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/efficientgo/core/errors"
+	config_util "github.com/prometheus/common/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+// Compile-time check if perRPCCredentials implements credentials.PerRPCCredentials interface.
+var _ credentials.PerRPCCredentials = &perRPCCredentials{}
+
+// perRPCCredentials attaches authentication and custom headers from series.Config as gRPC metadata
+// to every request.
+type perRPCCredentials struct {
+	conf        series.Config
+	tokenSource oauth2.TokenSource
+	requireTLS  bool
+}
+
+// hasAuth returns true if the config sends credentials, not only headers.
+func hasAuth(conf series.Config) bool {
+	return conf.BearerToken != "" || conf.BearerTokenFile != "" || conf.BasicAuth != nil || conf.OAuth2 != nil
+}
+
+// newPerRPCCredentials returns credentials for the given config, or nil if no authentication nor headers are configured.
+// Credentials require TLS, unless allowed to be sent in plain text.
+func newPerRPCCredentials(conf series.Config) (*perRPCCredentials, error) {
+	if !hasAuth(conf) && len(conf.Headers) == 0 {
+		return nil, nil
+	}
+
+	c := &perRPCCredentials{conf: conf, requireTLS: hasAuth(conf) && !conf.AllowInsecureCredentials}
+	if conf.OAuth2 != nil {
+		secret := string(conf.OAuth2.ClientSecret)
+		if conf.OAuth2.ClientSecretFile != "" {
+			b, err := os.ReadFile(conf.OAuth2.ClientSecretFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "read oauth2 client secret file")
+			}
+			secret = strings.TrimSpace(string(b))
+		}
+
+		// Token endpoint can have its own TLS and proxy configuration.
+		client, err := config_util.NewClientFromConfig(config_util.HTTPClientConfig{
+			TLSConfig: conf.OAuth2.TLSConfig,
+			ProxyURL:  conf.OAuth2.ProxyURL,
+		}, "oauth2")
+		if err != nil {
+			return nil, errors.Wrap(err, "create oauth2 HTTP client")
+		}
+
+		params := make(map[string][]string, len(conf.OAuth2.EndpointParams))
+		for k, v := range conf.OAuth2.EndpointParams {
+			params[k] = []string{v}
+		}
+		cc := &clientcredentials.Config{
+			ClientID:       conf.OAuth2.ClientID,
+			ClientSecret:   secret,
+			Scopes:         conf.OAuth2.Scopes,
+			TokenURL:       conf.OAuth2.TokenURL,
+			EndpointParams: params,
+		}
+		c.tokenSource = cc.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, client))
+	}
+	return c, nil
+}
+
+func (c *perRPCCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	md := make(map[string]string, len(c.conf.Headers)+1)
+	for k, v := range c.conf.Headers {
+		// gRPC metadata keys are always lowercase.
+		md[strings.ToLower(k)] = v
+	}
+
+	switch {
+	case c.conf.BearerToken != "":
+		md["authorization"] = "Bearer " + string(c.conf.BearerToken)
+	case c.conf.BearerTokenFile != "":
+		// Read the file on every request, so rotated tokens are picked up.
+		b, err := os.ReadFile(c.conf.BearerTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read bearer token file")
+		}
+		md["authorization"] = "Bearer " + strings.TrimSpace(string(b))
+	case c.conf.BasicAuth != nil:
+		password := string(c.conf.BasicAuth.Password)
+		if c.conf.BasicAuth.PasswordFile != "" {
+			b, err := os.ReadFile(c.conf.BasicAuth.PasswordFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "read basic auth password file")
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		md["authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.conf.BasicAuth.Username+":"+password))
+	case c.tokenSource != nil:
+		tok, err := c.tokenSource.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "get oauth2 token")
+		}
+		md["authorization"] = tok.Type() + " " + tok.AccessToken
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity returns true if credentials are configured and not allowed to be sent in plain text.
+// Headers alone are sent over any connection.
+func (c *perRPCCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
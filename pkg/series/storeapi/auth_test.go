@@ -0,0 +1,79 @@
+// Copyright (c) The Thanos Community Authors.
+// Licensed under the Apache License 2.0.
+
+package storeapi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	config_util "github.com/prometheus/common/config"
+
+	"github.com/thanos-community/obslytics/pkg/series"
+)
+
+func TestPerRPCCredentials(t *testing.T) {
+	creds, err := newPerRPCCredentials(series.Config{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, creds == nil)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	testutil.Ok(t, os.WriteFile(tokenFile, []byte("from-file\n"), 0600))
+
+	for _, tcase := range []struct {
+		name     string
+		conf     series.Config
+		expected map[string]string
+	}{
+		{
+			name:     "bearer token with headers",
+			conf:     series.Config{BearerToken: "secret", Headers: map[string]string{"X-Scope-OrgID": "team-a"}},
+			expected: map[string]string{"authorization": "Bearer secret", "x-scope-orgid": "team-a"},
+		},
+		{
+			name:     "bearer token file",
+			conf:     series.Config{BearerTokenFile: tokenFile},
+			expected: map[string]string{"authorization": "Bearer from-file"},
+		},
+		{
+			name:     "basic auth",
+			conf:     series.Config{BasicAuth: &config_util.BasicAuth{Username: "user", Password: "pass"}},
+			expected: map[string]string{"authorization": "Basic dXNlcjpwYXNz"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			creds, err := newPerRPCCredentials(tcase.conf)
+			testutil.Ok(t, err)
+
+			md, err := creds.GetRequestMetadata(context.Background())
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, md)
+			// Credentials require TLS, headers alone do not.
+			testutil.Equals(t, tcase.conf.BearerToken != "" || tcase.conf.BearerTokenFile != "" || tcase.conf.BasicAuth != nil, creds.RequireTransportSecurity())
+		})
+	}
+
+	creds, err = newPerRPCCredentials(series.Config{Headers: map[string]string{"X-Scope-OrgID": "team-a"}})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !creds.RequireTransportSecurity())
+}
+
+func TestNewSeries_InsecureCredentials(t *testing.T) {
+	conf := series.Config{Endpoint: "localhost:10901", BearerToken: "secret"}
+	_, err := NewSeries(log.NewNopLogger(), conf)
+	testutil.NotOk(t, err)
+
+	// Credentials are sent in plain text only when explicitly allowed.
+	conf.AllowInsecureCredentials = true
+	s, err := NewSeries(log.NewNopLogger(), conf)
+	testutil.Ok(t, err)
+	testutil.Ok(t, s.Close())
+
+	creds, err := newPerRPCCredentials(conf)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !creds.RequireTransportSecurity())
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/efficientgo/core/errors"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/thanos-io/thanos/pkg/extgrpc"
@@ -24,64 +25,137 @@ import (
 type Series struct {
 	logger log.Logger
 	conf   series.Config
+	conn   *grpc.ClientConn
 }
 
+// NewSeries creates StoreAPI reader. The gRPC connection is established lazily and reused by all reads
+// until Close is called.
 func NewSeries(logger log.Logger, conf series.Config) (Series, error) {
-	return Series{logger: logger, conf: conf}, nil
-}
-
-func (i Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
 	// set as true for authenticated connection if cert, key and/or ca are defined.
-	secure := i.conf.TLSConfig.CertFile != "" ||
-		i.conf.TLSConfig.KeyFile != "" ||
-		i.conf.TLSConfig.CAFile != ""
-	dialOpts, err := extgrpc.StoreClientGRPCOpts(i.logger, nil, tracing.NoopTracer(),
+	secure := conf.TLSConfig.CertFile != "" ||
+		conf.TLSConfig.KeyFile != "" ||
+		conf.TLSConfig.CAFile != ""
+	dialOpts, err := extgrpc.StoreClientGRPCOpts(logger, nil, tracing.NoopTracer(),
 		secure,
-		i.conf.TLSConfig.InsecureSkipVerify,
-		i.conf.TLSConfig.CertFile,
-		i.conf.TLSConfig.KeyFile,
-		i.conf.TLSConfig.CAFile,
-		i.conf.Endpoint,
+		conf.TLSConfig.InsecureSkipVerify,
+		conf.TLSConfig.CertFile,
+		conf.TLSConfig.KeyFile,
+		conf.TLSConfig.CAFile,
+		conf.Endpoint,
 	)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "error initializing GRPC options")
+		return Series{}, errors.Wrap(err, "error initializing GRPC options")
+	}
+
+	if !secure && hasAuth(conf) {
+		if !conf.AllowInsecureCredentials {
+			return Series{}, errors.New("credentials require TLS (tls_config), set allow_insecure_credentials to send them in plain text")
+		}
+		level.Warn(logger).Log("msg", "sending credentials over plain text gRPC connection", "endpoint", conf.Endpoint)
+	}
+	creds, err := newPerRPCCredentials(conf)
+	if err != nil {
+		return Series{}, errors.Wrap(err, "error initializing GRPC credentials")
+	}
+	if creds != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
 	}
 
-	conn, err := grpc.DialContext(ctx, i.conf.Endpoint, dialOpts...)
+	conn, err := grpc.Dial(conf.Endpoint, dialOpts...)
 	if err != nil {
-		return nil, errors.Wrap(err, "error initializing GRPC dial context")
+		return Series{}, errors.Wrap(err, "error initializing GRPC dial context")
 	}
+	return Series{logger: logger, conf: conf, conn: conn}, nil
+}
+
+func (i Series) Read(ctx context.Context, params series.Params) (series.Set, error) {
+	return i.series(ctx, params, false)
+}
 
+// ListSeries implements series.SeriesLister. Chunks are not requested from the store.
+func (i Series) ListSeries(ctx context.Context, params series.Params) (series.Set, error) {
+	return i.series(ctx, params, true)
+}
+
+func (i Series) series(ctx context.Context, params series.Params, skipChunks bool) (series.Set, error) {
 	matchers, err := storepb.PromMatchersToMatchers(params.Matchers...)
 	if err != nil {
 		return nil, err
 	}
 
-	client := storepb.NewStoreClient(conn)
+	// Stream is canceled on iterator close, so it does not leak when not fully consumed.
+	ctx, cancel := context.WithCancel(ctx)
+	client := storepb.NewStoreClient(i.conn)
 	seriesClient, err := client.Series(ctx, &storepb.SeriesRequest{
 		MinTime:                 timestamp.FromTime(params.MinTime),
 		MaxTime:                 timestamp.FromTime(params.MaxTime),
 		Matchers:                matchers,
 		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+		ShardInfo:               params.Shard.StorepbShardInfo(),
+		SkipChunks:              skipChunks,
 	})
 	if err != nil {
+		cancel()
 		return nil, errors.Wrapf(err, "storepb.Series against %v", i.conf.Endpoint)
 	}
 
-	return &iterator{
+	// Not all StoreAPI implementations support sharding, make sure only series of the shard are returned.
+	return series.FilterShard(&iterator{
 		ctx:    ctx,
-		conn:   conn,
+		cancel: cancel,
 		client: seriesClient,
 		mint:   timestamp.FromTime(params.MinTime),
 		maxt:   timestamp.FromTime(params.MaxTime),
-	}, nil
+	}, params.Shard), nil
+}
+
+// LabelNames implements series.LabelsReader.
+func (i Series) LabelNames(ctx context.Context, params series.Params) ([]string, error) {
+	matchers, err := storepb.PromMatchersToMatchers(params.Matchers...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := storepb.NewStoreClient(i.conn).LabelNames(ctx, &storepb.LabelNamesRequest{
+		Start:                   timestamp.FromTime(params.MinTime),
+		End:                     timestamp.FromTime(params.MaxTime),
+		Matchers:                matchers,
+		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "storepb.LabelNames against %v", i.conf.Endpoint)
+	}
+	return resp.Names, nil
+}
+
+// LabelValues implements series.LabelsReader.
+func (i Series) LabelValues(ctx context.Context, name string, params series.Params) ([]string, error) {
+	matchers, err := storepb.PromMatchersToMatchers(params.Matchers...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := storepb.NewStoreClient(i.conn).LabelValues(ctx, &storepb.LabelValuesRequest{
+		Label:                   name,
+		Start:                   timestamp.FromTime(params.MinTime),
+		End:                     timestamp.FromTime(params.MaxTime),
+		Matchers:                matchers,
+		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "storepb.LabelValues against %v", i.conf.Endpoint)
+	}
+	return resp.Values, nil
+}
+
+// Close closes the underlying gRPC connection.
+func (i Series) Close() error {
+	return i.conn.Close()
 }
 
 // iterator implements input.Set.
 type iterator struct {
 	ctx           context.Context
-	conn          *grpc.ClientConn
+	cancel        context.CancelFunc
 	client        storepb.Store_SeriesClient
 	currentSeries *storepb.Series
 
@@ -91,17 +165,22 @@ type iterator struct {
 }
 
 func (i *iterator) Next() bool {
-	seriesResp, err := i.client.Recv()
-	if err == io.EOF {
-		return false
+	for {
+		seriesResp, err := i.client.Recv()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			i.err = err
+			return false
+		}
+
+		// Skip warnings and hints.
+		if s := seriesResp.GetSeries(); s != nil {
+			i.currentSeries = s
+			return true
+		}
 	}
-	if err != nil {
-		i.err = err
-		return false
-	}
-
-	i.currentSeries = seriesResp.GetSeries()
-	return true
 }
 
 func (i *iterator) At() storage.Series {
@@ -121,9 +200,6 @@ func (i *iterator) Err() error {
 }
 
 func (i *iterator) Close() error {
-	if err := i.client.CloseSend(); err != nil {
-		return err
-	}
-
-	return i.conn.Close()
+	defer i.cancel()
+	return i.client.CloseSend()
 }